@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OrganizationQuotaOverrideSpec defines the overridden limits of an organization
+type OrganizationQuotaOverrideSpec struct {
+	// Reason documents why the override was granted.
+	//+kubebuilder:validation:MinLength=1
+	Reason string `json:"reason"`
+
+	// ExpiresAt is the time after which the override stops applying.
+	// The override applies indefinitely if not set.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// NamespaceQuota overrides the maximum number of namespaces the organization is allowed to create.
+	//+kubebuilder:validation:Minimum=0
+	NamespaceQuota *int `json:"namespaceQuota,omitempty"`
+
+	// MemoryPerCoreLimit overrides the fair use limit of memory per CPU core for all namespaces of the organization.
+	// The validate-request-ratio.appuio.io/limit annotation on a namespace takes precedence.
+	// Must be a positive quantity, for example 4Gi.
+	//+kubebuilder:validation:XValidation:rule="quantity(string(self)).isGreaterThan(quantity('0'))",message="memoryPerCoreLimit must be a positive quantity"
+	MemoryPerCoreLimit *resource.Quantity `json:"memoryPerCoreLimit,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Namespace Quota",type=integer,JSONPath=`.spec.namespaceQuota`
+//+kubebuilder:printcolumn:name="Expires At",type=date,JSONPath=`.spec.expiresAt`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.spec.reason`,priority=1
+
+// OrganizationQuotaOverride is the Schema for the OrganizationQuotaOverrides API.
+// The name of the override is the name of the organization it applies to.
+type OrganizationQuotaOverride struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec OrganizationQuotaOverrideSpec `json:"spec,omitempty"`
+}
+
+// Active returns true if the override has not expired at the given time.
+func (o OrganizationQuotaOverride) Active(now time.Time) bool {
+	return o.Spec.ExpiresAt == nil || now.Before(o.Spec.ExpiresAt.Time)
+}
+
+//+kubebuilder:object:root=true
+
+// OrganizationQuotaOverrideList contains a list of OrganizationQuotaOverride
+type OrganizationQuotaOverrideList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OrganizationQuotaOverride `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OrganizationQuotaOverride{}, &OrganizationQuotaOverrideList{})
+}
@@ -0,0 +1,46 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OrganizationQuotaUsageStatus defines the observed quota usage of an organization
+type OrganizationQuotaUsageStatus struct {
+	// Namespaces is the number of namespaces of the organization.
+	Namespaces int `json:"namespaces"`
+	// NamespaceQuota is the maximum number of namespaces the organization is allowed to create.
+	NamespaceQuota int `json:"namespaceQuota"`
+	// NamespaceQuotaSource is where the namespace quota is defined.
+	// One of ZoneUsageProfile, Legacy, OrganizationQuotaOverride or OverrideConfigMap.
+	// Empty if no quota is configured.
+	NamespaceQuotaSource string `json:"namespaceQuotaSource,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Namespaces",type=integer,JSONPath=`.status.namespaces`
+//+kubebuilder:printcolumn:name="Quota",type=integer,JSONPath=`.status.namespaceQuota`
+//+kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.status.namespaceQuotaSource`
+
+// OrganizationQuotaUsage is the Schema for the OrganizationQuotaUsages API.
+// It is managed by the agent and reports the quota usage of the organization with the same name.
+type OrganizationQuotaUsage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status OrganizationQuotaUsageStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OrganizationQuotaUsageList contains a list of OrganizationQuotaUsage
+type OrganizationQuotaUsageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OrganizationQuotaUsage `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OrganizationQuotaUsage{}, &OrganizationQuotaUsageList{})
+}
@@ -3,6 +3,7 @@ package v1
 import (
 	controlv1 "github.com/appuio/control-api/apis/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // ZoneUsageProfileSpec defines the desired state of ZoneUsageProfile
@@ -11,14 +12,150 @@ type ZoneUsageProfileSpec struct {
 	UpstreamSpec controlv1.UsageProfileSpec `json:"upstreamSpec"`
 }
 
+const (
+	// ConditionReady is true if the ZoneUsageProfile is applied to all namespaces of the organizations.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true if the ZoneUsageProfile failed to apply to or prune resources from some namespaces.
+	ConditionDegraded = "Degraded"
+
+	// MaxApplyFailures is the maximum number of failures reported in the status of a ZoneUsageProfile.
+	MaxApplyFailures = 10
+	// MaxDryRunChanges is the maximum number of dry run changes reported in the status of a ZoneUsageProfile.
+	MaxDryRunChanges = 20
+	// MaxRevisionHistory is the maximum number of completely rolled out revisions of a ZoneUsageProfile kept as ZoneUsageProfileRevisions.
+	MaxRevisionHistory = 5
+)
+
+// RolloutPhase is the phase of the rollout of a generation of a ZoneUsageProfile.
+type RolloutPhase string
+
+const (
+	// RolloutPhaseProgressing is the phase of a rollout applying the generation stage by stage.
+	RolloutPhaseProgressing RolloutPhase = "Progressing"
+	// RolloutPhaseCompleted is the phase of a rollout having applied the generation to all namespaces.
+	RolloutPhaseCompleted RolloutPhase = "Completed"
+	// RolloutPhaseHalted is the phase of a rollout stopped because too many resources failed to apply.
+	// The rollout resumes once the resources apply again.
+	RolloutPhaseHalted RolloutPhase = "Halted"
+	// RolloutPhaseRolledBack is the phase of a rollout replaced by a previous revision with the rollback annotation.
+	RolloutPhaseRolledBack RolloutPhase = "RolledBack"
+)
+
+// DryRunAction is the action applying a ZoneUsageProfile would take on a resource.
+type DryRunAction string
+
+const (
+	// DryRunActionCreate creates a resource missing in the namespace.
+	DryRunActionCreate DryRunAction = "Create"
+	// DryRunActionUpdate updates a resource differing from the ZoneUsageProfile.
+	DryRunActionUpdate DryRunAction = "Update"
+	// DryRunActionDelete deletes a resource no longer defined in or selected by the ZoneUsageProfile.
+	DryRunActionDelete DryRunAction = "Delete"
+)
+
 // ZoneUsageProfileStatus defines the observed state of ZoneUsageProfile
 type ZoneUsageProfileStatus struct {
-	// TODO(bastjan): track applied resources to be able to clean up.
+	// ObservedGeneration is the generation of the ZoneUsageProfile last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready and Degraded conditions of the ZoneUsageProfile.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// NamespacesApplied is the number of namespaces all resources of the ZoneUsageProfile are applied to.
+	//+optional
+	NamespacesApplied int `json:"namespacesApplied"`
+	// Failures are the namespace and resource pairs that failed to apply.
+	// At most MaxApplyFailures failures are reported.
+	Failures []ApplyFailure `json:"failures,omitempty"`
+
+	// AppliedResources are the resources applied to the namespaces of the organizations.
+	// Resources no longer in the spec are pruned from the namespaces.
+	AppliedResources []AppliedResource `json:"appliedResources,omitempty"`
+
+	// DryRun is the result of the last dry run of the ZoneUsageProfile.
+	// The dry run is computed before applying the ZoneUsageProfile, each namespace is diffed against the revision assigned by the rollout.
+	// Only set while the ZoneUsageProfile has the dry run annotation.
+	DryRun *ZoneUsageProfileDryRun `json:"dryRun,omitempty"`
+
+	// Rollout is the state of the rollout of the current generation of the ZoneUsageProfile.
+	// Completely rolled out revisions are kept as ZoneUsageProfileRevisions.
+	Rollout *ZoneUsageProfileRollout `json:"rollout,omitempty"`
+}
+
+// ZoneUsageProfileRollout is the state of the staged rollout of a generation of a ZoneUsageProfile.
+type ZoneUsageProfileRollout struct {
+	// Generation is the generation of the ZoneUsageProfile being rolled out.
+	Generation int64        `json:"generation"`
+	Phase      RolloutPhase `json:"phase"`
+	// Stage is the index of the current stage of the rollout.
+	// The canary namespaces are the first stage, if configured.
+	Stage int `json:"stage"`
+	// Stages is the number of stages of the rollout.
+	Stages int `json:"stages"`
+	// StageStartedAt is the time the current stage was started.
+	StageStartedAt metav1.Time `json:"stageStartedAt,omitempty"`
+	Message        string      `json:"message,omitempty"`
+}
+
+// ZoneUsageProfileDryRun are the changes applying a ZoneUsageProfile would make to the namespaces of the organizations.
+type ZoneUsageProfileDryRun struct {
+	// ObservedGeneration is the generation of the ZoneUsageProfile the dry run was computed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Creates is the number of resources that would be created.
+	Creates int `json:"creates"`
+	// Updates is the number of resources that would be updated.
+	Updates int `json:"updates"`
+	// Deletes is the number of resources that would be deleted.
+	Deletes int `json:"deletes"`
+	// Changes are the resources that would change.
+	// At most MaxDryRunChanges changes are reported in the status.
+	Changes []DryRunChange `json:"changes,omitempty"`
+	// Failures are the resources the dry run failed for.
+	// At most MaxApplyFailures failures are reported in the status.
+	Failures []ApplyFailure `json:"failures,omitempty"`
+}
+
+// DryRunChange is a change applying a ZoneUsageProfile would make to a resource in a namespace.
+type DryRunChange struct {
+	Namespace string `json:"namespace"`
+	// Resource is the name of the resource in the ZoneUsageProfile.
+	Resource string       `json:"resource"`
+	Kind     string       `json:"kind"`
+	Action   DryRunAction `json:"action"`
+	// Diff is the unified diff of the YAML representation of the resource.
+	Diff string `json:"diff,omitempty"`
+}
+
+// AppliedResource identifies a resource applied to the namespaces of the organizations.
+type AppliedResource struct {
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+	// Name is the name of the resource in the namespaces.
+	Name string `json:"name"`
+}
+
+// GroupVersionKind returns the GroupVersionKind of the resource.
+func (r AppliedResource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// ApplyFailure is a resource that failed to apply to a namespace.
+type ApplyFailure struct {
+	Namespace string `json:"namespace"`
+	// Resource is the name of the resource in the ZoneUsageProfile.
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Namespaces",type=integer,JSONPath=`.status.namespacesApplied`
+//+kubebuilder:printcolumn:name="Rollout",type=string,JSONPath=`.status.rollout.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ZoneUsageProfile is the Schema for the ZoneUsageProfiles API
 type ZoneUsageProfile struct {
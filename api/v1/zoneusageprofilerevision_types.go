@@ -0,0 +1,47 @@
+package v1
+
+import (
+	controlv1 "github.com/appuio/control-api/apis/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ZoneUsageProfileRevisionSpec is a completely rolled out revision of a ZoneUsageProfile.
+type ZoneUsageProfileRevisionSpec struct {
+	// Profile is the name of the ZoneUsageProfile the revision belongs to.
+	Profile string `json:"profile"`
+	// Generation is the generation of the ZoneUsageProfile the revision was rolled out for.
+	Generation int64 `json:"generation"`
+	// UpstreamSpec is the spec of the upstream UsageProfile of the revision.
+	UpstreamSpec controlv1.UsageProfileSpec `json:"upstreamSpec"`
+	// CompletedAt is the time the rollout of the revision completed.
+	CompletedAt metav1.Time `json:"completedAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.spec.profile`
+//+kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.spec.generation`
+//+kubebuilder:printcolumn:name="Completed At",type=date,JSONPath=`.spec.completedAt`
+
+// ZoneUsageProfileRevision is the Schema for the ZoneUsageProfileRevisions API.
+// Revisions are created by the agent after a rollout of a ZoneUsageProfile completed and are owned by the ZoneUsageProfile.
+// They are used to keep the last completed revision in namespaces not yet reached by a rollout and to roll back.
+type ZoneUsageProfileRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ZoneUsageProfileRevisionSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ZoneUsageProfileRevisionList contains a list of ZoneUsageProfileRevision
+type ZoneUsageProfileRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZoneUsageProfileRevision `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ZoneUsageProfileRevision{}, &ZoneUsageProfileRevisionList{})
+}
@@ -5,18 +5,224 @@
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ZoneUsageProfile) DeepCopyInto(out *ZoneUsageProfile) {
+func (in *AppliedResource) DeepCopyInto(out *AppliedResource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedResource.
+func (in *AppliedResource) DeepCopy() *AppliedResource {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplyFailure) DeepCopyInto(out *ApplyFailure) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplyFailure.
+func (in *ApplyFailure) DeepCopy() *ApplyFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplyFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DryRunChange) DeepCopyInto(out *DryRunChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DryRunChange.
+func (in *DryRunChange) DeepCopy() *DryRunChange {
+	if in == nil {
+		return nil
+	}
+	out := new(DryRunChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaOverride) DeepCopyInto(out *OrganizationQuotaOverride) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaOverride.
+func (in *OrganizationQuotaOverride) DeepCopy() *OrganizationQuotaOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OrganizationQuotaOverride) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaOverrideList) DeepCopyInto(out *OrganizationQuotaOverrideList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OrganizationQuotaOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaOverrideList.
+func (in *OrganizationQuotaOverrideList) DeepCopy() *OrganizationQuotaOverrideList {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaOverrideList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OrganizationQuotaOverrideList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaOverrideSpec) DeepCopyInto(out *OrganizationQuotaOverrideSpec) {
+	*out = *in
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.NamespaceQuota != nil {
+		in, out := &in.NamespaceQuota, &out.NamespaceQuota
+		*out = new(int)
+		**out = **in
+	}
+	if in.MemoryPerCoreLimit != nil {
+		in, out := &in.MemoryPerCoreLimit, &out.MemoryPerCoreLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaOverrideSpec.
+func (in *OrganizationQuotaOverrideSpec) DeepCopy() *OrganizationQuotaOverrideSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaOverrideSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaUsage) DeepCopyInto(out *OrganizationQuotaUsage) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Status = in.Status
 }
 
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaUsage.
+func (in *OrganizationQuotaUsage) DeepCopy() *OrganizationQuotaUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OrganizationQuotaUsage) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaUsageList) DeepCopyInto(out *OrganizationQuotaUsageList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OrganizationQuotaUsage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaUsageList.
+func (in *OrganizationQuotaUsageList) DeepCopy() *OrganizationQuotaUsageList {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaUsageList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OrganizationQuotaUsageList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrganizationQuotaUsageStatus) DeepCopyInto(out *OrganizationQuotaUsageStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrganizationQuotaUsageStatus.
+func (in *OrganizationQuotaUsageStatus) DeepCopy() *OrganizationQuotaUsageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OrganizationQuotaUsageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfile) DeepCopyInto(out *ZoneUsageProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfile.
 func (in *ZoneUsageProfile) DeepCopy() *ZoneUsageProfile {
 	if in == nil {
@@ -35,6 +241,31 @@ func (in *ZoneUsageProfile) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfileDryRun) DeepCopyInto(out *ZoneUsageProfileDryRun) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]DryRunChange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]ApplyFailure, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileDryRun.
+func (in *ZoneUsageProfileDryRun) DeepCopy() *ZoneUsageProfileDryRun {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUsageProfileDryRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZoneUsageProfileList) DeepCopyInto(out *ZoneUsageProfileList) {
 	*out = *in
@@ -67,6 +298,97 @@ func (in *ZoneUsageProfileList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfileRevision) DeepCopyInto(out *ZoneUsageProfileRevision) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileRevision.
+func (in *ZoneUsageProfileRevision) DeepCopy() *ZoneUsageProfileRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUsageProfileRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ZoneUsageProfileRevision) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfileRevisionList) DeepCopyInto(out *ZoneUsageProfileRevisionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ZoneUsageProfileRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileRevisionList.
+func (in *ZoneUsageProfileRevisionList) DeepCopy() *ZoneUsageProfileRevisionList {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUsageProfileRevisionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ZoneUsageProfileRevisionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfileRevisionSpec) DeepCopyInto(out *ZoneUsageProfileRevisionSpec) {
+	*out = *in
+	in.UpstreamSpec.DeepCopyInto(&out.UpstreamSpec)
+	in.CompletedAt.DeepCopyInto(&out.CompletedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileRevisionSpec.
+func (in *ZoneUsageProfileRevisionSpec) DeepCopy() *ZoneUsageProfileRevisionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUsageProfileRevisionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUsageProfileRollout) DeepCopyInto(out *ZoneUsageProfileRollout) {
+	*out = *in
+	in.StageStartedAt.DeepCopyInto(&out.StageStartedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileRollout.
+func (in *ZoneUsageProfileRollout) DeepCopy() *ZoneUsageProfileRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUsageProfileRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZoneUsageProfileSpec) DeepCopyInto(out *ZoneUsageProfileSpec) {
 	*out = *in
@@ -86,6 +408,33 @@ func (in *ZoneUsageProfileSpec) DeepCopy() *ZoneUsageProfileSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZoneUsageProfileStatus) DeepCopyInto(out *ZoneUsageProfileStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]ApplyFailure, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedResources != nil {
+		in, out := &in.AppliedResources, &out.AppliedResources
+		*out = make([]AppliedResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(ZoneUsageProfileDryRun)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(ZoneUsageProfileRollout)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUsageProfileStatus.
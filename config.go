@@ -2,9 +2,12 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
 
+	"github.com/appuio/appuio-cloud-agent/controllers"
 	"github.com/appuio/appuio-cloud-agent/limits"
+	"github.com/appuio/appuio-cloud-agent/ratio"
 	"go.uber.org/multierr"
 	"gopkg.in/inf.v0"
 	corev1 "k8s.io/api/core/v1"
@@ -19,15 +22,32 @@ type Config struct {
 	// UserDefaultOrganizationAnnotation is the annotation the default organization setting for a user is stored in.
 	UserDefaultOrganizationAnnotation string
 
-	// QuotaOverrideNamespace is the namespace where the quota overrides for organizations are stored.
+	// QuotaOverrideNamespace is the namespace where the deprecated quota override ConfigMaps for organizations are stored.
+	// OrganizationQuotaOverride resources should be used instead.
 	QuotaOverrideNamespace string
 
+	// UsageProfileLabel is the label on organizations selecting the ZoneUsageProfile of the organization.
+	// The label is synced from the Organization in the control API to all namespaces of the organization.
+	// Organizations not selecting a profile use the profile selected by the `-usage-profile` flag.
+	// Organizations can't select a profile if empty.
+	UsageProfileLabel string
+	// UsageProfileRollout configures the staged rollout of ZoneUsageProfile changes.
+	// Changes are applied to the canary namespaces first, then to growing percentages of the other namespaces in waves.
+	// Changes are applied to all namespaces at once if empty.
+	// Completely rolled out revisions are kept as ZoneUsageProfileRevisions, rollbacks are unavailable without them.
+	UsageProfileRollout controllers.RolloutStrategy
+
+	// NamespaceQuotaWarnUsagePercentage is the percentage of the namespace quota of an organization
+	// above which a warning is returned when creating a namespace. Disabled if 0.
+	NamespaceQuotaWarnUsagePercentage int
+
 	// MemoryPerCoreLimit is the fair use limit of memory usage per CPU core
 	// it is deprecated and will be removed in a future version.
 	// Use MemoryPerCoreLimits: {Limit: "XGi"} instead.
 	MemoryPerCoreLimit *resource.Quantity
 	// MemoryPerCoreLimits is the fair use limit of memory usage per CPU core
 	// It is possible to select limits by node selector labels
+	// Limits can optionally be enforced by denying workload creations that push the ratio below a hard limit.
 	MemoryPerCoreLimits limits.Limits
 	// MemoryPerCoreWarnThreshold is the threshold at which a warning is emitted if the memory per core limit is exceeded.
 	// Should be a decimal number resembling a percentage (e.g. "0.8" for 80%), represented as a string.
@@ -35,6 +55,34 @@ type Config struct {
 	// A threshold of 0.95 would mean that the warnings are emitted if the ratio is below 95% of the limit.
 	// Thus adding a leniency of 5% to the limit.
 	MemoryPerCoreWarnThreshold *inf.Dec
+	// MemoryPerCoreNodeClassLabels are the node labels identifying the node class pods are grouped by for the ratio.
+	// The labels are taken from the node for scheduled pods and from the required node affinity for unscheduled pods.
+	// Only `.spec.nodeSelector` is used if empty.
+	MemoryPerCoreNodeClassLabels []string
+	// MemoryPerCoreAggregation is the scope the memory per core ratio is aggregated over.
+	// Can be Namespace (default) or Organization.
+	// Organization sums up the requests of all namespaces with the same OrganizationLabel.
+	// Organization ratios are checked against the limits of OrganizationQuotaOverrides only and exported once per organization.
+	MemoryPerCoreAggregation ratio.Aggregation
+	// MemoryPerCoreCountedPodPhases are the pod phases counted in the memory per core ratio.
+	// Defaults to Running and Pending.
+	MemoryPerCoreCountedPodPhases []corev1.PodPhase
+	// MemoryPerCoreCountUnschedulablePods counts Pending pods the scheduler failed to schedule in the memory per core ratio.
+	// By default only Pending pods which are scheduled or schedulable are counted.
+	MemoryPerCoreCountUnschedulablePods bool
+	// MemoryPerCoreLimitAnalysis enables separate warnings for workloads without CPU or memory requests (e.g. BestEffort pods)
+	// and for workloads whose memory to CPU limit ratio is below the fair use limit.
+	MemoryPerCoreLimitAnalysis bool
+	// MemoryPerCoreWarningTemplate is a Go text/template for the warning emitted if the memory per core ratio is below the limit.
+	// The fields of ratio.WarningData are available in the template.
+	// Newlines are replaced with spaces since Kubernetes drops warnings containing newlines.
+	// A built-in English warning is used if empty.
+	MemoryPerCoreWarningTemplate string
+	// MemoryPerCoreWarningLanguageTemplates are language specific variants of MemoryPerCoreWarningTemplate keyed by language.
+	MemoryPerCoreWarningLanguageTemplates map[string]string
+	// MemoryPerCoreWarningLanguageAnnotation is the annotation on users or namespaces selecting the language of the warning.
+	// The annotation on the user takes precedence over the annotation on the namespace.
+	MemoryPerCoreWarningLanguageAnnotation string
 
 	// Privileged* is a list of the given type allowed to bypass restrictions.
 	// Wildcards are supported (e.g. "system:serviceaccount:default:*" or "cluster-*-operator").
@@ -106,10 +154,58 @@ func (c Config) Validate() error {
 	if c.OrganizationLabel == "" {
 		errs = append(errs, errors.New("OrganizationLabel must not be empty"))
 	}
+	if err := c.MemoryPerCoreLimits.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid MemoryPerCoreLimits: %w", err))
+	}
+	for _, phase := range c.MemoryPerCoreCountedPodPhases {
+		switch phase {
+		case corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown:
+		default:
+			errs = append(errs, fmt.Errorf("unknown pod phase %q in MemoryPerCoreCountedPodPhases", phase))
+		}
+	}
+	if _, err := c.RatioWarner(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid MemoryPerCoreWarningTemplate: %w", err))
+	}
+	if c.NamespaceQuotaWarnUsagePercentage < 0 || c.NamespaceQuotaWarnUsagePercentage > 100 {
+		errs = append(errs, fmt.Errorf("NamespaceQuotaWarnUsagePercentage must be between 0 and 100, got %d", c.NamespaceQuotaWarnUsagePercentage))
+	}
+	if err := c.UsageProfileRollout.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid UsageProfileRollout: %w", err))
+	}
+	switch c.MemoryPerCoreAggregation {
+	case "", ratio.AggregationNamespace, ratio.AggregationOrganization:
+	default:
+		errs = append(errs, fmt.Errorf("unknown MemoryPerCoreAggregation %q", c.MemoryPerCoreAggregation))
+	}
 
 	return multierr.Combine(errs...)
 }
 
+// RatioPodTemplateExtractors returns the pod template extractors counting the pods selected by the MemoryPerCoreCount* options.
+func (c Config) RatioPodTemplateExtractors() ratio.PodTemplateExtractors {
+	counter := ratio.DefaultPodCounter()
+	if c.MemoryPerCoreCountedPodPhases != nil {
+		counter.Phases = c.MemoryPerCoreCountedPodPhases
+	}
+	counter.CountUnschedulable = c.MemoryPerCoreCountUnschedulablePods
+
+	extractors := ratio.DefaultPodTemplateExtractors()
+	extractors.Register(corev1.SchemeGroupVersion.WithKind("Pod").GroupKind(), ratio.NewPodExtractor(counter))
+	return extractors
+}
+
+// RatioWarner returns the ratio.Warner configured by the MemoryPerCoreWarning* options.
+func (c Config) RatioWarner() (*ratio.Warner, error) {
+	w, err := ratio.NewWarner(c.MemoryPerCoreWarningTemplate, c.MemoryPerCoreWarningLanguageTemplates)
+	if err != nil {
+		return nil, err
+	}
+	w.OrganizationLabel = c.OrganizationLabel
+	w.LanguageAnnotation = c.MemoryPerCoreWarningLanguageAnnotation
+	return w, nil
+}
+
 func migrateConfig(c Config) (Config, []string) {
 	warnings := make([]string, 0)
 
@@ -5,10 +5,12 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -113,3 +115,80 @@ func Test_Config_MemoryPerCoreWarnThreshold(t *testing.T) {
 		})
 	}
 }
+
+func Test_Config_MemoryPerCoreWarningTemplate(t *testing.T) {
+	c := Config{
+		OrganizationLabel:                      "appuio.io/organization",
+		MemoryPerCoreWarningTemplate:           "Ratio {{ .Ratio }} too low",
+		MemoryPerCoreWarningLanguageTemplates:  map[string]string{"de": "Verhältnis {{ .Ratio }} zu tief"},
+		MemoryPerCoreWarningLanguageAnnotation: "appuio.io/language",
+	}
+	require.NoError(t, c.Validate())
+
+	w, err := c.RatioWarner()
+	require.NoError(t, err)
+	assert.Equal(t, "appuio.io/organization", w.OrganizationLabel)
+	assert.Equal(t, "appuio.io/language", w.LanguageAnnotation)
+
+	c.MemoryPerCoreWarningLanguageTemplates["de"] = "{{ .Verhältnis }}"
+	assert.Error(t, c.Validate())
+}
+
+func Test_Config_MemoryPerCoreCountedPodPhases(t *testing.T) {
+	pending := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}
+	podGK := corev1.SchemeGroupVersion.WithKind("Pod").GroupKind()
+
+	c := Config{OrganizationLabel: "appuio.io/organization"}
+	require.NoError(t, c.Validate())
+	extractor, ok := c.RatioPodTemplateExtractors().Extractor(podGK)
+	require.True(t, ok)
+	replicas, _, _ := extractor.PodTemplate(pending)
+	assert.Equal(t, int32(1), replicas, "pending pods should be counted by default")
+
+	c.MemoryPerCoreCountedPodPhases = []corev1.PodPhase{corev1.PodRunning}
+	require.NoError(t, c.Validate())
+	extractor, ok = c.RatioPodTemplateExtractors().Extractor(podGK)
+	require.True(t, ok)
+	replicas, _, _ = extractor.PodTemplate(pending)
+	assert.Equal(t, int32(0), replicas)
+
+	c.MemoryPerCoreCountedPodPhases = []corev1.PodPhase{"Sleeping"}
+	assert.Error(t, c.Validate())
+}
+
+func Test_Config_NamespaceQuotaWarnUsagePercentage(t *testing.T) {
+	c := Config{OrganizationLabel: "appuio.io/organization", NamespaceQuotaWarnUsagePercentage: 80}
+	require.NoError(t, c.Validate())
+
+	c.NamespaceQuotaWarnUsagePercentage = 101
+	assert.Error(t, c.Validate())
+	c.NamespaceQuotaWarnUsagePercentage = -1
+	assert.Error(t, c.Validate())
+}
+
+func Test_Config_UsageProfileRollout(t *testing.T) {
+	tmp := t.TempDir()
+	configPath := filepath.Join(tmp, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+OrganizationLabel: appuio.io/organization
+UsageProfileRollout:
+  CanaryNamespaceSelector:
+    matchLabels:
+      canary: "true"
+  WavePercentages: [10, 50]
+  WavePause: 1h
+  MaxFailures: 2
+`), 0o644))
+	c, _, err := ConfigFromFile(configPath)
+	require.NoError(t, err)
+	require.NoError(t, c.Validate())
+	assert.Equal(t, map[string]string{"canary": "true"}, c.UsageProfileRollout.CanaryNamespaceSelector.MatchLabels)
+	assert.Equal(t, []int{10, 50}, c.UsageProfileRollout.WavePercentages)
+	assert.Equal(t, time.Hour, c.UsageProfileRollout.WavePause.Duration)
+	assert.Equal(t, 2, c.UsageProfileRollout.MaxFailures)
+
+	c.UsageProfileRollout.WavePercentages = []int{50, 10}
+	assert.Error(t, c.Validate())
+	c.UsageProfileRollout.WavePercentages = []int{10, 150}
+	assert.Error(t, c.Validate())
+}
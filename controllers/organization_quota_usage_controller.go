@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/nsquota"
+)
+
+var (
+	organizationNamespaces = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_organization_namespaces",
+		Help: "Number of namespaces of the organization.",
+	}, []string{"organization"})
+	organizationNamespaceQuota = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_organization_namespace_quota",
+		Help: "Maximum number of namespaces of the organization by source of the quota.",
+	}, []string{"organization", "source"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(organizationNamespaces, organizationNamespaceQuota)
+}
+
+// OrganizationQuotaUsageReconciler reconciles the OrganizationQuotaUsage of organizations.
+// Requests are keyed by the organization name.
+type OrganizationQuotaUsageReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+
+	OrganizationLabel string
+	// Resolver resolves the namespace quota of the organizations.
+	// Must be configured like the namespace quota validator.
+	Resolver nsquota.Resolver
+}
+
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotausages,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotausages/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotaoverrides,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=zoneusageprofiles,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile updates the OrganizationQuotaUsage and the metrics of an organization.
+// The OrganizationQuotaUsage is removed if the organization has no namespaces.
+func (r *OrganizationQuotaUsageReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx).WithValues("organization", req.Name)
+
+	var nsList corev1.NamespaceList
+	if err := r.List(ctx, &nsList, client.MatchingLabels{r.OrganizationLabel: req.Name}); err != nil {
+		l.Error(err, "unable to list namespaces")
+		return ctrl.Result{}, err
+	}
+
+	usage := &cloudagentv1.OrganizationQuotaUsage{
+		ObjectMeta: ctrl.ObjectMeta{
+			Name: req.Name,
+		},
+	}
+	if len(nsList.Items) == 0 {
+		organizationNamespaces.DeletePartialMatch(prometheus.Labels{"organization": req.Name})
+		organizationNamespaceQuota.DeletePartialMatch(prometheus.Labels{"organization": req.Name})
+		return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, usage))
+	}
+
+	limit, err := r.Resolver.Limit(log.IntoContext(ctx, l), req.Name)
+	if err != nil && !errors.Is(err, nsquota.ErrNoProfileSelected) {
+		l.Error(err, "unable to resolve namespace quota")
+		return ctrl.Result{}, err
+	}
+
+	organizationNamespaces.WithLabelValues(req.Name).Set(float64(len(nsList.Items)))
+	organizationNamespaceQuota.DeletePartialMatch(prometheus.Labels{"organization": req.Name})
+	if limit.Source != "" {
+		organizationNamespaceQuota.WithLabelValues(req.Name, string(limit.Source)).Set(float64(limit.Namespaces))
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, usage, func() error { return nil }); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to create OrganizationQuotaUsage: %w", err)
+	}
+	if limit.Source == nsquota.SourceOverrideConfigMap {
+		r.Recorder.Eventf(usage, corev1.EventTypeWarning, "DeprecatedOverrideConfigMap",
+			"Namespace quota is overridden by the deprecated ConfigMap %s/%s, use an OrganizationQuotaOverride instead",
+			r.Resolver.QuotaOverrideNamespace, nsquota.OverrideConfigMapName(req.Name))
+	}
+	status := cloudagentv1.OrganizationQuotaUsageStatus{
+		Namespaces:           len(nsList.Items),
+		NamespaceQuota:       limit.Namespaces,
+		NamespaceQuotaSource: string(limit.Source),
+	}
+	res, err := r.requeueAtOverrideExpiry(ctx, req.Name)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if usage.Status == status {
+		return res, nil
+	}
+	usage.Status = status
+	if err := r.Status().Update(ctx, usage); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update OrganizationQuotaUsage status: %w", err)
+	}
+	return res, nil
+}
+
+// requeueAtOverrideExpiry returns a result requeueing the organization when its active OrganizationQuotaOverride expires.
+// Expiring does not change the override, so no watch event would trigger the reconcile.
+func (r *OrganizationQuotaUsageReconciler) requeueAtOverrideExpiry(ctx context.Context, organization string) (ctrl.Result, error) {
+	var override cloudagentv1.OrganizationQuotaOverride
+	if err := r.Get(ctx, client.ObjectKey{Name: organization}, &override); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if override.Spec.ExpiresAt == nil || !override.Active(time.Now()) {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(override.Spec.ExpiresAt.Time)}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *OrganizationQuotaUsageReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	orgPredicate, err := labelExistsPredicate(r.OrganizationLabel)
+	if err != nil {
+		return fmt.Errorf("unable to create LabelSelectorPredicate: %w", err)
+	}
+	overrideConfigMapPredicate := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == r.Resolver.QuotaOverrideNamespace && strings.HasPrefix(obj.GetName(), nsquota.OverrideConfigMapName(""))
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cloudagentv1.OrganizationQuotaUsage{}).
+		Named("organization_quota_usage").
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToOrganization),
+			builder.WithPredicates(orgPredicate)).
+		Watches(&cloudagentv1.OrganizationQuotaOverride{}, &handler.EnqueueRequestForObject{}).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(mapOverrideConfigMapToOrganization),
+			builder.WithPredicates(overrideConfigMapPredicate)).
+		// Only the spec of ZoneUsageProfiles affects the namespace quota, status updates of the apply controller are ignored.
+		Watches(
+			&cloudagentv1.ZoneUsageProfile{},
+			handler.EnqueueRequestsFromMapFunc(r.mapToAllOrganizations),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// mapNamespaceToOrganization enqueues the organization of the namespace.
+func (r *OrganizationQuotaUsageReconciler) mapNamespaceToOrganization(_ context.Context, obj client.Object) []reconcile.Request {
+	org := obj.GetLabels()[r.OrganizationLabel]
+	if org == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: org}}}
+}
+
+// mapOverrideConfigMapToOrganization enqueues the organization of a deprecated override ConfigMap.
+func mapOverrideConfigMapToOrganization(_ context.Context, obj client.Object) []reconcile.Request {
+	org, ok := strings.CutPrefix(obj.GetName(), nsquota.OverrideConfigMapName(""))
+	if !ok || org == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: org}}}
+}
+
+// mapToAllOrganizations enqueues all organizations with namespaces.
+func (r *OrganizationQuotaUsageReconciler) mapToAllOrganizations(ctx context.Context, _ client.Object) []reconcile.Request {
+	var nsList corev1.NamespaceList
+	if err := r.List(ctx, &nsList, client.HasLabels{r.OrganizationLabel}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list namespaces")
+		return nil
+	}
+	seen := make(map[string]struct{})
+	reqs := make([]reconcile.Request, 0)
+	for _, ns := range nsList.Items {
+		org := ns.Labels[r.OrganizationLabel]
+		if _, ok := seen[org]; ok || org == "" {
+			continue
+		}
+		seen[org] = struct{}{}
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKey{Name: org}})
+	}
+	return reqs
+}
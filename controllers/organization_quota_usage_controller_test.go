@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	controlv1 "github.com/appuio/control-api/apis/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/nsquota"
+)
+
+func TestOrganizationQuotaUsageReconciler_Reconcile(t *testing.T) {
+	const orgLabel = "appuio.io/organization"
+	ctx := context.Background()
+
+	quota := 4
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, cloudagentv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&cloudagentv1.OrganizationQuotaUsage{}).
+		WithObjects(
+			newNamespace("a", map[string]string{orgLabel: "usage-acme"}, nil),
+			newNamespace("b", map[string]string{orgLabel: "usage-acme"}, nil),
+			newNamespace("c", map[string]string{orgLabel: "usage-other"}, nil),
+			&cloudagentv1.ZoneUsageProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "profile"},
+				Spec: cloudagentv1.ZoneUsageProfileSpec{
+					UpstreamSpec: controlv1.UsageProfileSpec{NamespaceCount: 2},
+				},
+			},
+		).
+		Build()
+
+	recorder := record.NewFakeRecorder(5)
+	subject := &OrganizationQuotaUsageReconciler{
+		Client:            c,
+		Recorder:          recorder,
+		OrganizationLabel: orgLabel,
+		Resolver: nsquota.Resolver{
+			Client:                 c,
+			SelectedProfile:        "profile",
+			QuotaOverrideNamespace: "overrides",
+		},
+	}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "usage-acme"}}
+	_, err := subject.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	var usage cloudagentv1.OrganizationQuotaUsage
+	require.NoError(t, c.Get(ctx, req.NamespacedName, &usage))
+	assert.Equal(t, cloudagentv1.OrganizationQuotaUsageStatus{
+		Namespaces:           2,
+		NamespaceQuota:       2,
+		NamespaceQuotaSource: "ZoneUsageProfile",
+	}, usage.Status)
+	assert.Equal(t, 2.0, testutil.ToFloat64(organizationNamespaces.WithLabelValues("usage-acme")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(organizationNamespaceQuota.WithLabelValues("usage-acme", "ZoneUsageProfile")))
+	assert.Empty(t, recorder.Events)
+
+	require.NoError(t, c.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "override-usage-acme", Namespace: "overrides"},
+		Data:       map[string]string{"namespaceQuota": "3"},
+	}))
+	_, err = subject.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(ctx, req.NamespacedName, &usage))
+	assert.Equal(t, 3, usage.Status.NamespaceQuota)
+	assert.Equal(t, "OverrideConfigMap", usage.Status.NamespaceQuotaSource)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "deprecated ConfigMap overrides/override-usage-acme")
+
+	require.NoError(t, c.Create(ctx, &cloudagentv1.OrganizationQuotaOverride{
+		ObjectMeta: metav1.ObjectMeta{Name: "usage-acme"},
+		Spec: cloudagentv1.OrganizationQuotaOverrideSpec{
+			Reason:         "test",
+			NamespaceQuota: &quota,
+		},
+	}))
+	res, err := subject.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter, "overrides without expiry should not requeue")
+	require.NoError(t, c.Get(ctx, req.NamespacedName, &usage))
+	assert.Equal(t, 4, usage.Status.NamespaceQuota)
+	assert.Equal(t, "OrganizationQuotaOverride", usage.Status.NamespaceQuotaSource)
+	assert.Equal(t, 4.0, testutil.ToFloat64(organizationNamespaceQuota.WithLabelValues("usage-acme", "OrganizationQuotaOverride")))
+	assert.False(t, organizationNamespaceQuota.DeleteLabelValues("usage-acme", "ZoneUsageProfile"), "metric of previous source should be removed")
+
+	var override cloudagentv1.OrganizationQuotaOverride
+	require.NoError(t, c.Get(ctx, req.NamespacedName, &override))
+	override.Spec.ExpiresAt = &metav1.Time{Time: time.Now().Add(time.Hour)}
+	require.NoError(t, c.Update(ctx, &override))
+	res, err = subject.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, res.RequeueAfter, float64(time.Minute), "should requeue when the override expires")
+
+	require.NoError(t, c.Delete(ctx, newNamespace("a", nil, nil)))
+	require.NoError(t, c.Delete(ctx, newNamespace("b", nil, nil)))
+	_, err = subject.Reconcile(ctx, req)
+	require.NoError(t, err)
+	err = c.Get(ctx, req.NamespacedName, &usage)
+	assert.True(t, apierrors.IsNotFound(err), "usage of organizations without namespaces should be removed")
+	assert.False(t, organizationNamespaces.DeleteLabelValues("usage-acme"), "metrics of organizations without namespaces should be removed")
+}
+
+func TestOrganizationQuotaUsageReconciler_mapToAllOrganizations(t *testing.T) {
+	const orgLabel = "appuio.io/organization"
+
+	c, _, _ := prepareClient(t,
+		newNamespace("a", map[string]string{orgLabel: "acme"}, nil),
+		newNamespace("b", map[string]string{orgLabel: "acme"}, nil),
+		newNamespace("c", map[string]string{orgLabel: "other"}, nil),
+		newNamespace("d", nil, nil),
+	)
+	subject := &OrganizationQuotaUsageReconciler{
+		Client:            c,
+		OrganizationLabel: orgLabel,
+	}
+
+	reqs := subject.mapToAllOrganizations(context.Background(), nil)
+	assert.ElementsMatch(t, []ctrl.Request{
+		{NamespacedName: client.ObjectKey{Name: "acme"}},
+		{NamespacedName: client.ObjectKey{Name: "other"}},
+	}, reqs)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	orgv1 "github.com/appuio/control-api/apis/organization/v1"
+	"go.uber.org/multierr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// OrganizationUsageProfileSyncReconciler syncs the ZoneUsageProfile selected by an organization in the foreign (Control-API) cluster
+// to the namespaces of the organization.
+// Requests are keyed by the organization name.
+type OrganizationUsageProfileSyncReconciler struct {
+	client.Client
+
+	ForeignClient client.Client
+
+	OrganizationLabel string
+	// UsageProfileLabel is the label on the upstream Organization selecting the ZoneUsageProfile of the organization.
+	// The label is set on all namespaces of the organization.
+	UsageProfileLabel string
+}
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;patch
+
+// Reconcile syncs the UsageProfileLabel of the upstream Organization to the namespaces of the organization.
+// The label is removed from the namespaces if the upstream Organization does not have the label.
+func (r *OrganizationUsageProfileSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx).WithValues("organization", req.Name)
+
+	var upstream orgv1.Organization
+	if err := r.ForeignClient.Get(ctx, client.ObjectKey{Name: req.Name}, &upstream); err != nil {
+		if apierrors.IsNotFound(err) {
+			l.Info("Upstream organization not found")
+			return ctrl.Result{}, nil
+		}
+		l.Error(err, "unable to get upstream Organization")
+		return ctrl.Result{}, err
+	}
+	profile := upstream.Labels[r.UsageProfileLabel]
+
+	var nsList corev1.NamespaceList
+	if err := r.List(ctx, &nsList, client.MatchingLabels{r.OrganizationLabel: req.Name}); err != nil {
+		l.Error(err, "unable to list namespaces")
+		return ctrl.Result{}, err
+	}
+
+	var errs []error
+	for _, ns := range nsList.Items {
+		if current, ok := ns.Labels[r.UsageProfileLabel]; current == profile && ok == (profile != "") {
+			continue
+		}
+		patch := client.MergeFrom(ns.DeepCopy())
+		if profile == "" {
+			delete(ns.Labels, r.UsageProfileLabel)
+		} else {
+			ns.Labels[r.UsageProfileLabel] = profile
+		}
+		if err := r.Patch(ctx, &ns, patch); err != nil {
+			errs = append(errs, fmt.Errorf("unable to patch namespace %q: %w", ns.Name, err))
+			continue
+		}
+		l.Info("Updated usage profile of namespace", "namespace", ns.Name, "profile", profile)
+	}
+
+	return ctrl.Result{}, multierr.Combine(errs...)
+}
+
+// SetupWithManagerAndForeignCluster sets up the controller with the Manager.
+func (r *OrganizationUsageProfileSyncReconciler) SetupWithManagerAndForeignCluster(mgr ctrl.Manager, foreign cluster.Cluster) error {
+	orgPredicate, err := labelExistsPredicate(r.OrganizationLabel)
+	if err != nil {
+		return fmt.Errorf("unable to create LabelSelectorPredicate: %w", err)
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("organization_usage_profile_sync").
+		WatchesRawSource(source.Kind(foreign.GetCache(), &orgv1.Organization{}, &handler.TypedEnqueueRequestForObject[*orgv1.Organization]{})).
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: obj.GetLabels()[r.OrganizationLabel]}}}
+			}),
+			builder.WithPredicates(orgPredicate)).
+		Complete(r)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	orgv1 "github.com/appuio/control-api/apis/organization/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrganizationUsageProfileSyncReconciler_Reconcile(t *testing.T) {
+	const orgLabel = "appuio.io/organization"
+	const profileLabel = "appuio.io/usage-profile"
+	ctx := context.Background()
+
+	c, _, _ := prepareClient(t,
+		newNamespace("a", map[string]string{orgLabel: "acme"}, nil),
+		newNamespace("b", map[string]string{orgLabel: "acme", profileLabel: "small"}, nil),
+		newNamespace("c", map[string]string{orgLabel: "other", profileLabel: "small"}, nil),
+	)
+
+	foreignScheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(foreignScheme))
+	require.NoError(t, orgv1.AddToScheme(foreignScheme))
+	upstream := &orgv1.Organization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "acme",
+			Labels: map[string]string{profileLabel: "large"},
+		},
+	}
+	foreign := fake.NewClientBuilder().WithScheme(foreignScheme).WithObjects(upstream).Build()
+
+	subject := &OrganizationUsageProfileSyncReconciler{
+		Client:            c,
+		ForeignClient:     foreign,
+		OrganizationLabel: orgLabel,
+		UsageProfileLabel: profileLabel,
+	}
+
+	_, err := subject.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "acme"}})
+	require.NoError(t, err)
+	for name, expected := range map[string]string{"a": "large", "b": "large", "c": "small"} {
+		var ns corev1.Namespace
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: name}, &ns))
+		assert.Equal(t, expected, ns.Labels[profileLabel], name)
+	}
+
+	upstream.Labels = nil
+	require.NoError(t, foreign.Update(ctx, upstream))
+	_, err = subject.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "acme"}})
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b"} {
+		var ns corev1.Namespace
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: name}, &ns))
+		assert.NotContains(t, ns.Labels, profileLabel, name)
+		assert.Equal(t, "acme", ns.Labels[orgLabel], name)
+	}
+
+	_, err = subject.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "unknown"}})
+	require.NoError(t, err, "should ignore organizations missing upstream")
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/appuio/appuio-cloud-agent/limits"
 	"github.com/appuio/appuio-cloud-agent/ratio"
 	"gopkg.in/inf.v0"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/tools/record"
 
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -28,6 +30,17 @@ type RatioReconciler struct {
 	Ratio              ratioFetcher
 	RatioLimits        limits.Limits
 	RatioWarnThreshold *inf.Dec
+
+	// OrganizationLabel is the namespace label used to determine the organization in metrics and for organization quota overrides.
+	OrganizationLabel string
+	// Aggregation must match the aggregation of the Ratio fetcher.
+	// If it is ratio.AggregationOrganization, the ratios of namespaces with the OrganizationLabel are checked against the limits of the organization
+	// and exported once per organization instead of once per namespace.
+	Aggregation ratio.Aggregation
+
+	// Warner renders the warning events.
+	// Ratio.Warn is used if nil.
+	Warner *ratio.Warner
 }
 
 type ratioFetcher interface {
@@ -35,7 +48,10 @@ type ratioFetcher interface {
 }
 
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;patch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotaoverrides,verbs=get;list;watch
 
 var eventReason = "TooMuchCPURequest"
 
@@ -43,43 +59,124 @@ var eventReason = "TooMuchCPURequest"
 func (r *RatioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx).WithValues("namespace", req.Namespace, "name", req.Name)
 
+	metrics := ratioMetricsRecorder{namespace: req.Namespace}
 	nsRatios, err := r.Ratio.FetchRatios(ctx, req.Namespace)
 	if err != nil {
 		if errors.Is(err, ratio.ErrorDisabled) {
 			l.V(1).Info("namespace disabled")
+			metrics.reset()
+			return ctrl.Result{}, nil
+		}
+		if apierrors.IsNotFound(err) {
+			l.V(1).Info("namespace not found")
+			metrics.reset()
 			return ctrl.Result{}, nil
 		}
 		l.Error(err, "failed to get ratio")
 		return ctrl.Result{}, err
 	}
 
+	ns := corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns); client.IgnoreNotFound(err) != nil {
+		l.Error(err, "failed to get namespace")
+		return ctrl.Result{}, err
+	}
+	var ratioLimits limits.Limits
+	if org := ratio.AggregatedOrganization(r.Aggregation, r.OrganizationLabel, ns); org != "" {
+		// The ratios are the same for all namespaces of the organization, drop metrics recorded for the namespace before switching to organization aggregation
+		metrics.reset()
+		metrics = ratioMetricsRecorder{organization: org}
+		ratioLimits, err = ratio.OrganizationLimits(ctx, r.Client, org, r.RatioLimits)
+	} else {
+		if r.OrganizationLabel != "" {
+			metrics.organization = ns.Labels[r.OrganizationLabel]
+		}
+		ratioLimits, err = ratio.OrganizationNamespaceLimits(ctx, r.Client, r.OrganizationLabel, r.RatioLimits, ns)
+	}
+	if err != nil {
+		l.Error(err, "failed to apply limit override, using default limits")
+	}
+	// Reset to drop node selectors without pods
+	metrics.reset()
+
+	belowHardLimit := false
 	for nodeSel, ratio := range nsRatios {
 		sel, err := labels.ConvertSelectorToLabelsMap(nodeSel)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("failed to convert node selector '%s' to labels map: %w", nodeSel, err)
 		}
-		limit := r.RatioLimits.GetLimitForNodeSelector(sel)
-		if limit == nil {
+		limit := ratioLimits.GetForNodeSelector(sel)
+		if limit == nil || limit.Limit == nil {
 			l.Info("no limit found for node selector", "nodeSelector", nodeSel)
+			metrics.record(nodeSel, ratio, nil, r.RatioWarnThreshold)
 			continue
 		}
+		metrics.record(nodeSel, ratio, limit.Limit, r.RatioWarnThreshold)
 
-		if ratio.Below(*limit, r.RatioWarnThreshold) {
+		if limit.Enforcement == limits.EnforcementDenyAfterGracePeriod && ratio.Below(*limit.GetHardLimit(), nil) {
+			belowHardLimit = true
+		}
+
+		if ratio.Below(*limit.Limit, r.RatioWarnThreshold) {
 			l.Info("recording warn event: ratio too low")
 
-			if err := r.warnPod(ctx, req.Name, req.Namespace, ratio, nodeSel, limit); err != nil {
+			msg := r.warning(ctx, req.Namespace, ratio, nodeSel, limit.Limit)
+			if err := r.warnPod(ctx, req.Name, req.Namespace, msg); err != nil {
 				l.Error(err, "failed to record event on pod")
 			}
-			if err := r.warnNamespace(ctx, req.Namespace, ratio, nodeSel, limit); err != nil {
+			if err := r.warnNamespace(ctx, req.Namespace, msg); err != nil {
 				l.Error(err, "failed to record event on namespace")
 			}
 		}
 	}
 
+	if err := r.updateBelowHardLimitSince(ctx, req.Namespace, belowHardLimit); err != nil {
+		l.Error(err, "failed to update hard limit annotation on namespace")
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
-func (r *RatioReconciler) warnPod(ctx context.Context, name, namespace string, nsRatio *ratio.Ratio, sel string, limit *resource.Quantity) error {
+// updateBelowHardLimitSince records since when the namespace is below an enforced hard limit in an annotation on the namespace.
+// The annotation is removed if the namespace is no longer below the hard limit.
+func (r *RatioReconciler) updateBelowHardLimitSince(ctx context.Context, name string, below bool) error {
+	ns := corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, &ns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	_, recorded := ns.Annotations[ratio.BelowHardLimitSinceAnnotation]
+	if below == recorded {
+		return nil
+	}
+
+	patch := client.MergeFrom(ns.DeepCopy())
+	if below {
+		if ns.Annotations == nil {
+			ns.Annotations = map[string]string{}
+		}
+		ns.Annotations[ratio.BelowHardLimitSinceAnnotation] = time.Now().Format(time.RFC3339)
+	} else {
+		delete(ns.Annotations, ratio.BelowHardLimitSinceAnnotation)
+	}
+	return r.Patch(ctx, &ns, patch)
+}
+
+// warning renders the warning for the given ratio.
+// The language is selected by the namespace.
+func (r *RatioReconciler) warning(ctx context.Context, namespace string, nsRatio *ratio.Ratio, sel string, limit *resource.Quantity) string {
+	if r.Warner == nil {
+		return nsRatio.Warn(limit, sel)
+	}
+	ns := corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		log.FromContext(ctx).Error(err, "failed to get namespace for warning")
+	}
+	return r.Warner.Warn(*nsRatio, limit, sel, ns, r.Warner.Language(&ns))
+}
+
+func (r *RatioReconciler) warnPod(ctx context.Context, name, namespace string, msg string) error {
 	pod := corev1.Pod{}
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: namespace,
@@ -88,10 +185,10 @@ func (r *RatioReconciler) warnPod(ctx context.Context, name, namespace string, n
 	if err != nil {
 		return err
 	}
-	r.Recorder.Event(&pod, "Warning", eventReason, nsRatio.Warn(limit, sel))
+	r.Recorder.Event(&pod, "Warning", eventReason, msg)
 	return nil
 }
-func (r *RatioReconciler) warnNamespace(ctx context.Context, name string, nsRatio *ratio.Ratio, sel string, limit *resource.Quantity) error {
+func (r *RatioReconciler) warnNamespace(ctx context.Context, name string, msg string) error {
 	ns := corev1.Namespace{}
 	err := r.Get(ctx, client.ObjectKey{
 		Name: name,
@@ -99,7 +196,7 @@ func (r *RatioReconciler) warnNamespace(ctx context.Context, name string, nsRati
 	if err != nil {
 		return err
 	}
-	r.Recorder.Event(&ns, "Warning", eventReason, nsRatio.Warn(limit, sel))
+	r.Recorder.Event(&ns, "Warning", eventReason, msg)
 	return nil
 }
 
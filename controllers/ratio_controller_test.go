@@ -3,9 +3,11 @@ package controllers
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/appuio/appuio-cloud-agent/limits"
 	"github.com/appuio/appuio-cloud-agent/ratio"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/inf.v0"
@@ -155,6 +157,135 @@ func TestRatioReconciler_RecordFailed(t *testing.T) {
 	requireNEvents(t, recorder, 0)
 }
 
+func TestRatioReconciler_BelowHardLimit(t *testing.T) {
+	c := prepareRatioTest(t, testRatioCfg{
+		limit:       resource.MustParse("4G"),
+		enforcement: limits.EnforcementDenyAfterGracePeriod,
+		fetchMemory: resource.MustParse("4G"),
+		fetchCPU:    resource.MustParse("1100m"),
+		obj: []client.Object{
+			testNs,
+			testPod,
+		},
+	})
+	_, err := c.Reconcile(context.TODO(), ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: testNs.Name,
+			Name:      testPod.Name,
+		},
+	})
+	require.NoError(t, err)
+
+	ns := corev1.Namespace{}
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(testNs), &ns))
+	since, err := time.Parse(time.RFC3339, ns.Annotations[ratio.BelowHardLimitSinceAnnotation])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), since, time.Minute)
+}
+
+func TestRatioReconciler_AboveHardLimit(t *testing.T) {
+	ns := testNs.DeepCopy()
+	ns.Annotations = map[string]string{
+		ratio.BelowHardLimitSinceAnnotation: time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+	c := prepareRatioTest(t, testRatioCfg{
+		limit:       resource.MustParse("4G"),
+		enforcement: limits.EnforcementDenyAfterGracePeriod,
+		fetchMemory: resource.MustParse("4G"),
+		fetchCPU:    resource.MustParse("900m"),
+		obj: []client.Object{
+			ns,
+			testPod,
+		},
+	})
+	_, err := c.Reconcile(context.TODO(), ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: testNs.Name,
+			Name:      testPod.Name,
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(testNs), ns))
+	assert.NotContains(t, ns.Annotations, ratio.BelowHardLimitSinceAnnotation)
+}
+
+func TestRatioReconciler_Metrics(t *testing.T) {
+	ns := testNs.DeepCopy()
+	ns.Name = "metrics"
+	ns.Labels = map[string]string{"appuio.io/organization": "acme"}
+	pod := testPod.DeepCopy()
+	pod.Namespace = ns.Name
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	subject := prepareRatioTest(t, testRatioCfg{
+		limit:       resource.MustParse("4Gi"),
+		fetchMemory: resource.MustParse("2Gi"),
+		fetchCPU:    resource.MustParse("1"),
+		obj: []client.Object{
+			ns,
+			pod,
+		},
+	})
+	subject.OrganizationLabel = "appuio.io/organization"
+
+	_, err := subject.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	lbls := []string{"metrics", "acme", ""}
+	assert.Equal(t, 1.0, testutil.ToFloat64(ratioCPURequests.WithLabelValues(lbls...)))
+	assert.Equal(t, float64(2<<30), testutil.ToFloat64(ratioMemoryRequests.WithLabelValues(lbls...)))
+	assert.Equal(t, float64(2<<30), testutil.ToFloat64(ratioMemoryPerCore.WithLabelValues(lbls...)))
+	assert.Equal(t, float64(4<<30), testutil.ToFloat64(ratioLimit.WithLabelValues(lbls...)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ratioBelowLimit.WithLabelValues(lbls...)))
+
+	subject.Ratio = fakeRatioFetcher{err: ratio.ErrorDisabled}
+	_, err = subject.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	for _, m := range ratioMetrics {
+		assert.False(t, m.DeleteLabelValues(lbls...), "metrics of disabled namespace should be removed")
+	}
+}
+
+func TestRatioReconciler_Metrics_OrganizationAggregation(t *testing.T) {
+	orgLabels := map[string]string{"appuio.io/organization": "metrics-org"}
+	nsA := testNs.DeepCopy()
+	nsA.Name = "metrics-a"
+	nsA.Labels = orgLabels
+	nsB := testNs.DeepCopy()
+	nsB.Name = "metrics-b"
+	nsB.Labels = orgLabels
+	nsB.Annotations = map[string]string{ratio.LimitOverrideAnnotation: "1Gi"}
+	podA := testPod.DeepCopy()
+	podA.Namespace = nsA.Name
+	podB := testPod.DeepCopy()
+	podB.Namespace = nsB.Name
+
+	subject := prepareRatioTest(t, testRatioCfg{
+		limit:       resource.MustParse("4Gi"),
+		fetchMemory: resource.MustParse("2Gi"),
+		fetchCPU:    resource.MustParse("1"),
+		obj:         []client.Object{nsA, nsB, podA, podB},
+	})
+	subject.OrganizationLabel = "appuio.io/organization"
+	subject.Aggregation = ratio.AggregationOrganization
+
+	for _, pod := range []*corev1.Pod{podA, podB} {
+		_, err := subject.Reconcile(context.TODO(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+		require.NoError(t, err)
+	}
+
+	lbls := []string{"", "metrics-org", ""}
+	assert.Equal(t, 1.0, testutil.ToFloat64(ratioCPURequests.WithLabelValues(lbls...)))
+	assert.Equal(t, float64(4<<30), testutil.ToFloat64(ratioLimit.WithLabelValues(lbls...)), "namespace limit annotation should not apply to the organization")
+	assert.Equal(t, 1.0, testutil.ToFloat64(ratioBelowLimit.WithLabelValues(lbls...)))
+	for _, ns := range []string{nsA.Name, nsB.Name} {
+		for _, m := range ratioMetrics {
+			assert.False(t, m.DeleteLabelValues(ns, "metrics-org", ""), "organization ratios should not be exported per namespace")
+		}
+	}
+}
+
 func requireNEvents(t *testing.T, recorder *record.FakeRecorder, n int) {
 	t.Helper()
 
@@ -168,6 +299,7 @@ func requireNEvents(t *testing.T, recorder *record.FakeRecorder, n int) {
 
 type testRatioCfg struct {
 	limit       resource.Quantity
+	enforcement limits.Enforcement
 	fetchErr    error
 	fetchCPU    resource.Quantity
 	fetchMemory resource.Quantity
@@ -202,7 +334,9 @@ func prepareRatioTest(t *testing.T, cfg testRatioCfg) *RatioReconciler {
 			}},
 		RatioLimits: limits.Limits{
 			{
-				Limit: &cfg.limit,
+				Limit:       &cfg.limit,
+				Enforcement: cfg.enforcement,
+				GracePeriod: metav1.Duration{Duration: time.Hour},
 			},
 		},
 		RatioWarnThreshold: cfg.threshold,
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/inf.v0"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/appuio/appuio-cloud-agent/ratio"
+)
+
+var ratioMetricLabels = []string{"namespace", "organization", "node_selector"}
+
+var (
+	ratioCPURequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_ratio_cpu_requests_cores",
+		Help: "Sum of the CPU requests of all pods in the namespace, or in the organization if the namespace is empty, by node selector.",
+	}, ratioMetricLabels)
+	ratioMemoryRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_ratio_memory_requests_bytes",
+		Help: "Sum of the memory requests of all pods in the namespace, or in the organization if the namespace is empty, by node selector.",
+	}, ratioMetricLabels)
+	ratioMemoryPerCore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_ratio_memory_per_core_bytes",
+		Help: "Memory to CPU request ratio of the namespace, or of the organization if the namespace is empty, by node selector. Not set if there are no CPU requests.",
+	}, ratioMetricLabels)
+	ratioLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_ratio_limit_memory_per_core_bytes",
+		Help: "Fair use memory to CPU request ratio limit applicable to the node selector.",
+	}, ratioMetricLabels)
+	ratioBelowLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "appuio_cloud_agent_ratio_below_limit",
+		Help: "1 if the memory to CPU request ratio of the namespace, or of the organization if the namespace is empty, is below the fair use limit including the warn threshold, 0 otherwise.",
+	}, ratioMetricLabels)
+
+	ratioMetrics = []*prometheus.GaugeVec{ratioCPURequests, ratioMemoryRequests, ratioMemoryPerCore, ratioLimit, ratioBelowLimit}
+)
+
+func init() {
+	for _, m := range ratioMetrics {
+		metrics.Registry.MustRegister(m)
+	}
+}
+
+// ratioMetricsRecorder records the ratios of a single namespace.
+// The ratios of an organization are recorded with an empty namespace if namespace is empty.
+type ratioMetricsRecorder struct {
+	namespace    string
+	organization string
+}
+
+// reset removes all recorded metrics of the namespace, or of the organization if namespace is empty.
+func (m ratioMetricsRecorder) reset() {
+	lbls := prometheus.Labels{"namespace": m.namespace}
+	if m.namespace == "" {
+		lbls["organization"] = m.organization
+	}
+	for _, v := range ratioMetrics {
+		v.DeletePartialMatch(lbls)
+	}
+}
+
+// record records the ratio of the given node selector.
+// limit is optional.
+func (m ratioMetricsRecorder) record(nodeSel string, r *ratio.Ratio, limit *resource.Quantity, threshold *inf.Dec) {
+	lbls := prometheus.Labels{"namespace": m.namespace, "organization": m.organization, "node_selector": nodeSel}
+
+	ratioCPURequests.With(lbls).Set(decToFloat(r.CPU))
+	ratioMemoryRequests.With(lbls).Set(decToFloat(r.Memory))
+	if q := r.Ratio(); q != nil {
+		ratioMemoryPerCore.With(lbls).Set(q.AsApproximateFloat64())
+	}
+	if limit == nil {
+		return
+	}
+	ratioLimit.With(lbls).Set(limit.AsApproximateFloat64())
+	below := 0.0
+	if r.Below(*limit, threshold) {
+		below = 1
+	}
+	ratioBelowLimit.With(lbls).Set(below)
+}
+
+func decToFloat(d *inf.Dec) float64 {
+	return resource.NewDecimalQuantity(*d, resource.DecimalSI).AsApproximateFloat64()
+}
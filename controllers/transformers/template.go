@@ -0,0 +1,107 @@
+package transformers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"go.uber.org/multierr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// TemplateData is the data available to templates in string fields of transformed objects.
+type TemplateData struct {
+	// Organization is the organization of the namespace.
+	Organization string
+	// Namespace is the name of the namespace.
+	Namespace string
+	// Labels are the labels of the namespace.
+	Labels map[string]string
+	// Annotations are the annotations of the namespace.
+	Annotations map[string]string
+	// Zone is the identifier of the zone.
+	Zone string
+}
+
+// TemplateAnnotation enables rendering templates in an object if set to "true".
+const TemplateAnnotation = "cloud-agent.appuio.io/template"
+
+// NewTemplateTransformer returns a new Transformer that renders string fields containing Go templates.
+// Only objects with the TemplateAnnotation set to "true" are rendered, so values containing "{{" are kept as is by default.
+// The templates are rendered with the TemplateData of the namespace, for example `{{ .Organization }}` or `{{ index .Labels "foo" }}`.
+// The organization is read from the given organizationLabel of the namespace.
+// Only values are rendered, map keys are kept as is.
+// Templates failing to render are returned as ErrRender.
+func NewTemplateTransformer(organizationLabel, zone string) Transformer {
+	return &templateTransformer{
+		OrganizationLabel: organizationLabel,
+		Zone:              zone,
+	}
+}
+
+type templateTransformer struct {
+	OrganizationLabel string
+	Zone              string
+}
+
+func (t *templateTransformer) Transform(ctx context.Context, u *unstructured.Unstructured, contextNs *corev1.Namespace) error {
+	if u.GetAnnotations()[TemplateAnnotation] != "true" {
+		return nil
+	}
+
+	data := TemplateData{
+		Organization: contextNs.Labels[t.OrganizationLabel],
+		Namespace:    contextNs.Name,
+		Labels:       contextNs.Labels,
+		Annotations:  contextNs.Annotations,
+		Zone:         t.Zone,
+	}
+
+	var errors []error
+	u.SetUnstructuredContent(renderTemplates(u.UnstructuredContent(), "", data, &errors).(map[string]any))
+	if err := multierr.Combine(errors...); err != nil {
+		return fmt.Errorf("%w: %w", ErrRender, err)
+	}
+	return nil
+}
+
+// renderTemplates renders all strings containing templates in the given value.
+// Maps and slices are modified in place.
+// Strings failing to render are kept as is and the error is appended to errors.
+func renderTemplates(v any, path string, data TemplateData, errors *[]error) any {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v
+		}
+		rendered, err := renderTemplate(v, data)
+		if err != nil {
+			*errors = append(*errors, fmt.Errorf("unable to render template at %q: %w", path, err))
+			return v
+		}
+		return rendered
+	case map[string]any:
+		for k, e := range v {
+			v[k] = renderTemplates(e, path+"."+k, data, errors)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = renderTemplates(e, fmt.Sprintf("%s[%d]", path, i), data, errors)
+		}
+	}
+	return v
+}
+
+func renderTemplate(text string, data TemplateData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,112 @@
+package transformers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_TemplateTransformer_Transform(t *testing.T) {
+	subject := NewTemplateTransformer("appuio.io/organization", "zone-a")
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "acme-prod",
+			Labels:      map[string]string{"appuio.io/organization": "acme", "env": "prod"},
+			Annotations: map[string]string{"owner": "team-a"},
+		},
+	}
+
+	t.Run("network policy", func(t *testing.T) {
+		toTransform := deepCopyToUnstructured(t, &networkingv1.NetworkPolicy{
+			TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "allow-from-same-organization",
+				Annotations: map[string]string{TemplateAnnotation: "true"},
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"appuio.io/organization": "{{ .Organization }}"},
+						},
+					}},
+				}},
+			},
+		})
+		require.NoError(t, subject.Transform(context.Background(), toTransform, ns))
+		var np networkingv1.NetworkPolicy
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(toTransform.Object, &np))
+		assert.Equal(t, "acme", np.Spec.Ingress[0].From[0].NamespaceSelector.MatchLabels["appuio.io/organization"])
+	})
+
+	t.Run("role binding", func(t *testing.T) {
+		toTransform := deepCopyToUnstructured(t, &rbacv1.RoleBinding{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "organization-admin",
+				Annotations: map[string]string{
+					TemplateAnnotation: "true",
+					"description":      `{{ .Namespace }} in {{ .Zone }}, env {{ index .Labels "env" }}, owned by {{ .Annotations.owner }}`,
+				},
+			},
+			Subjects: []rbacv1.Subject{{Kind: "Group", Name: "{{ .Organization }}"}},
+			RoleRef:  rbacv1.RoleRef{Kind: "ClusterRole", Name: "admin"},
+		})
+		require.NoError(t, subject.Transform(context.Background(), toTransform, ns))
+		var rb rbacv1.RoleBinding
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(toTransform.Object, &rb))
+		assert.Equal(t, "acme-prod in zone-a, env prod, owned by team-a", rb.Annotations["description"])
+		assert.Equal(t, "acme", rb.Subjects[0].Name)
+		assert.Equal(t, "admin", rb.RoleRef.Name)
+	})
+
+	t.Run("invalid templates", func(t *testing.T) {
+		toTransform := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]any{
+					"annotations": map[string]any{TemplateAnnotation: "true"},
+				},
+				"data": map[string]any{
+					"invalid": "{{ .Organization",
+					"missing": "{{ .Annotations.missing }}",
+					"valid":   "{{ .Organization }}",
+				},
+			},
+		}
+		err := subject.Transform(context.Background(), toTransform, ns)
+		require.ErrorIs(t, err, ErrRender)
+		assert.ErrorContains(t, err, ".data.invalid")
+		assert.ErrorContains(t, err, ".data.missing")
+		data, _, _ := unstructured.NestedStringMap(toTransform.Object, "data")
+		assert.Equal(t, map[string]string{
+			"invalid": "{{ .Organization",
+			"missing": "{{ .Annotations.missing }}",
+			"valid":   "acme",
+		}, data, "should keep values failing to render")
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		toTransform := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"data": map[string]any{
+					"helm": "{{ .Values.foo }}",
+				},
+			},
+		}
+		require.NoError(t, subject.Transform(context.Background(), toTransform, ns))
+		data, _, _ := unstructured.NestedStringMap(toTransform.Object, "data")
+		assert.Equal(t, map[string]string{"helm": "{{ .Values.foo }}"}, data, "should not render objects without the template annotation")
+	})
+}
@@ -2,11 +2,16 @@ package transformers
 
 import (
 	"context"
+	"errors"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// ErrRender is returned by transformers failing to render an object, the object must not be applied.
+// Objects failing to transform with other errors are applied partially transformed.
+var ErrRender = errors.New("unable to render object")
+
 type Transformer interface {
 	Transform(ctx context.Context, u *unstructured.Unstructured, namespace *corev1.Namespace) error
 }
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
@@ -9,13 +10,20 @@ import (
 	userv1 "github.com/openshift/api/user/v1"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/managedfields/managedfieldstest"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 
 	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
@@ -49,11 +57,112 @@ func prepareClient(t *testing.T, initObjs ...client.Object) (client.WithWatch, *
 	client := fake.NewClientBuilder().
 		WithScheme(scheme).
 		WithObjects(initObjs...).
+		WithInterceptorFuncs(fakeServerSideApply()).
 		Build()
 
 	return client, scheme, record.NewFakeRecorder(5)
 }
 
+// fakeServerSideApply emulates server-side apply patches, which are not supported by the fake client.
+// The applied object replaces the existing object, field ownership is not tracked.
+// Dry run patches return the applied object without persisting it.
+func fakeServerSideApply() interceptor.Funcs {
+	return interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch.Type() != types.ApplyPatchType {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			po := &client.PatchOptions{}
+			po.ApplyOptions(opts)
+			if len(po.DryRun) > 0 {
+				return nil
+			}
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+			if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+				if apierrors.IsNotFound(err) {
+					return c.Create(ctx, obj)
+				}
+				return err
+			}
+			obj.SetResourceVersion(existing.GetResourceVersion())
+			return c.Update(ctx, obj)
+		},
+	}
+}
+
+// fieldManagedServerSideApply emulates server-side apply patches and updates with field ownership tracking.
+// Fields are tracked with a deduced schema, lists are treated as atomic.
+// Only unstructured objects are supported.
+func fieldManagedServerSideApply(t *testing.T) interceptor.Funcs {
+	t.Helper()
+
+	fieldManager := func(obj client.Object) *managedfields.FieldManager {
+		return managedfieldstest.NewFakeFieldManager(managedfields.NewDeducedTypeConverter(), obj.GetObjectKind().GroupVersionKind())
+	}
+	live := func(ctx context.Context, c client.WithWatch, obj client.Object) (*unstructured.Unstructured, bool, error) {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+		err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+		if apierrors.IsNotFound(err) {
+			existing.SetNamespace(obj.GetNamespace())
+			existing.SetName(obj.GetName())
+			return existing, false, nil
+		}
+		return existing, err == nil, err
+	}
+	store := func(ctx context.Context, c client.WithWatch, obj client.Object, newObj runtime.Object, exists bool) error {
+		u := newObj.(*unstructured.Unstructured)
+		if exists {
+			if err := c.Update(ctx, u); err != nil {
+				return err
+			}
+		} else if err := c.Create(ctx, u); err != nil {
+			return err
+		}
+		obj.(*unstructured.Unstructured).SetUnstructuredContent(u.UnstructuredContent())
+		return nil
+	}
+
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*unstructured.Unstructured); !ok {
+				return c.Create(ctx, obj, opts...)
+			}
+			co := &client.CreateOptions{}
+			co.ApplyOptions(opts)
+			existing, _, err := live(ctx, c, obj)
+			if err != nil {
+				return err
+			}
+			newObj, err := fieldManager(obj).Update(existing, obj, co.FieldManager)
+			if err != nil {
+				return err
+			}
+			return store(ctx, c, obj, newObj, false)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch.Type() != types.ApplyPatchType {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			po := &client.PatchOptions{}
+			po.ApplyOptions(opts)
+			existing, exists, err := live(ctx, c, obj)
+			if err != nil {
+				return err
+			}
+			newObj, err := fieldManager(obj).Apply(existing, obj, po.FieldManager, ptr.Deref(po.Force, false))
+			if err != nil {
+				return err
+			}
+			if len(po.DryRun) > 0 {
+				return nil
+			}
+			return store(ctx, c, obj, newObj, exists)
+		},
+	}
+}
+
 // ensureGVK ensures that the object has a valid GVK set.
 // It does modify the object and also returns the modified object for convenience.
 func ensureGVK(t *testing.T, scheme *runtime.Scheme, obj client.Object) client.Object {
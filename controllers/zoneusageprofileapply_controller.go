@@ -1,18 +1,30 @@
 package controllers
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/csaupgrade"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
@@ -27,10 +39,14 @@ import (
 
 	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
 	"github.com/appuio/appuio-cloud-agent/controllers/transformers"
+	"github.com/appuio/appuio-cloud-agent/nsquota"
 )
 
 // ZoneUsageProfileApplyReconciler reconciles a ZoneUsageProfile object.
 // It applies the resources defined in the ZoneUsageProfile to all namespaces with the given organization label.
+// Resources can be restricted to namespaces matching the label selector in their resourceNamespaceSelectorAnnotation,
+// and namespaces can opt out of resources with the namespaceOptOutAnnotation.
+// The resources are server-side applied, fields not defined in the ZoneUsageProfile can be managed by others.
 // It dynamically watches the resources defined in the ZoneUsageProfile to keep those resources in sync.
 // Reconciler must be setup with SetupWithManager.
 type ZoneUsageProfileApplyReconciler struct {
@@ -49,112 +65,535 @@ type ZoneUsageProfileApplyReconciler struct {
 	OrganizationLabel string
 	Transformers      []transformers.Transformer
 
-	// SelectedProfile applies only selected profile, if set. Dynamic selection is in future tickets.
+	// SelectedProfile applies only selected profile, if set.
+	// Namespaces selecting a profile with the UsageProfileLabel are only applied the selected profile.
 	SelectedProfile string
+	// UsageProfileLabel is the namespace label selecting the profile of the namespace.
+	// Namespaces can't select a profile if empty.
+	UsageProfileLabel string
+
+	// DisablePruning disables the deletion of resources no longer defined in the ZoneUsageProfile or no longer selected for a namespace.
+	DisablePruning bool
+
+	// MaxConcurrentNamespaces is the maximum number of namespaces a ZoneUsageProfile is applied to in parallel.
+	// Namespaces are applied sequentially if less than 1.
+	MaxConcurrentNamespaces int
+	// RateLimiter limits the rate of namespaces a ZoneUsageProfile is applied to.
+	// Not rate limited if nil.
+	RateLimiter *rate.Limiter
+
+	// Rollout configures the staged rollout of ZoneUsageProfile changes.
+	// Changes are applied to all namespaces at once if not configured.
+	Rollout RolloutStrategy
 }
 
 const resourceOwnerLabel = "cloud-agent.appuio.io/usage-profile"
 
+// resourceNamespaceSelectorAnnotation is the annotation on resources of a ZoneUsageProfile restricting the namespaces the resource is applied to.
+// The value is a label selector, for example `appuio.io/preview=true` or `!gpu`.
+const resourceNamespaceSelectorAnnotation = "cloud-agent.appuio.io/namespace-selector"
+
+// namespaceOptOutAnnotation is the annotation on namespaces listing the comma-separated names of ZoneUsageProfile resources not to apply to the namespace.
+const namespaceOptOutAnnotation = "cloud-agent.appuio.io/usage-profile-opt-out"
+
+// usageProfileFieldManager is the field manager used to server-side apply the resources of ZoneUsageProfiles.
+const usageProfileFieldManager = "appuio-cloud-agent-usage-profile"
+
+// legacyFieldManager is the field manager of resources updated by versions not using server-side apply.
+// It is derived from the user agent of the agent.
+const legacyFieldManager = "appuio-cloud-agent"
+
 //+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=zoneusageprofiles,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=zoneusageprofiles/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=zoneusageprofiles/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cloudagent.appuio.io,resources=zoneusageprofilerevisions,verbs=get;list;watch;create;update;patch;delete
 
 //+kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile applies a ZoneUsageProfile to all namespaces with the given organization label.
+// Requests with a namespace only apply the ZoneUsageProfile to the given namespace, see namespaceRequest.
+// Requests without a namespace apply the ZoneUsageProfile to all namespaces in parallel, bounded by MaxConcurrentNamespaces and the RateLimiter.
+// Resources applied by the ZoneUsageProfile but no longer defined in it, or applied to namespaces no longer selecting it, are pruned, unless pruning is disabled.
+// The applied resources, the apply failures, and the Ready and Degraded conditions are recorded in the status of the ZoneUsageProfile.
+// The status is only updated by requests without a namespace.
+// The changes applying ZoneUsageProfiles with the dryRunAnnotation makes are dry run before applying them and reported in the status.
+// Changes are rolled out in the stages of the RolloutStrategy, the rollout is recorded in the status and completed rollouts as ZoneUsageProfileRevisions.
 // It returns an error if more than one ZoneUsageProfile try to manage a resource.
 func (r *ZoneUsageProfileApplyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
 	l.Info("Reconciling ZoneUsageProfile")
 
-	if r.SelectedProfile != "" && r.SelectedProfile != req.Name {
-		l.Info("Skipping ZoneUsageProfile", "name", req.Name, "selectedProfile", r.SelectedProfile)
-		return ctrl.Result{}, nil
-	}
-
 	var profile cloudagentv1.ZoneUsageProfile
-	if err := r.Client.Get(ctx, req.NamespacedName, &profile); err != nil {
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: req.Name}, &profile); err != nil {
 		l.Error(err, "unable to get ZoneUsageProfile")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if req.Namespace != "" {
+		return ctrl.Result{}, r.reconcileNamespace(ctx, profile, req.Namespace)
+	}
+
+	now := time.Now()
+	plan, err := r.planRollout(ctx, profile, now)
+	if err != nil {
+		l.Error(err, "unable to plan rollout")
+		r.Recorder.Event(&profile, "Warning", "RolloutFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	var errors []error
+	dryRun, dryRunErr := r.statusDryRun(ctx, profile, plan)
+	if dryRunErr != nil {
+		l.Error(dryRunErr, "unable to dry run ZoneUsageProfile")
+		errors = append(errors, dryRunErr)
+	}
+
 	var orgNsl corev1.NamespaceList
 	if err := r.Client.List(ctx, &orgNsl, client.HasLabels{r.OrganizationLabel}); err != nil {
 		l.Error(err, "unable to list Namespaces")
 		return ctrl.Result{}, err
 	}
 
-	var errors []error
+	var mu sync.Mutex
+	var failures []cloudagentv1.ApplyFailure
+	namespacesApplied := 0
+	rolloutFailures := 0
+	orgNamespaces := make(map[string]bool, len(orgNsl.Items))
+
+	var g errgroup.Group
+	g.SetLimit(max(r.MaxConcurrentNamespaces, 1))
 	for _, orgNs := range orgNsl.Items {
-		l := l.WithValues("namespace", orgNs.Name)
-		if orgNs.DeletionTimestamp != nil && time.Now().After(orgNs.DeletionTimestamp.Time) {
-			l.Info("Skipping Namespace", "reason", "Namespace is being deleted")
+		applies := r.profileAppliesToNamespace(ctx, profile, orgNs)
+		orgNamespaces[orgNs.Name] = applies
+		if !applies {
 			continue
 		}
-		l.Info("Applying UsageProfile to Namespace")
-		for name, resource := range profile.Spec.UpstreamSpec.Resources {
-			l := l.WithValues("resourceName", name)
-			l.Info("Applying UsageProfile Resource to Namespace")
+		if r.RateLimiter != nil {
+			if err := r.RateLimiter.Wait(ctx); err != nil {
+				_ = g.Wait()
+				return ctrl.Result{}, fmt.Errorf("unable to wait for rate limiter: %w", err)
+			}
+		}
+		g.Go(func() error {
+			nsProfile, reached := plan.profileForNamespace(orgNs)
+			nsFailures := r.applyToNamespace(ctx, nsProfile, orgNs)
 
-			if err := r.applyResourceToNamespace(ctx, name, orgNs, resource, profile); err != nil {
-				l.Error(err, "unable to create or update resource")
-				r.Recorder.Event(&profile, "Warning", "ApplyFailed", fmt.Sprintf("unable to create or update resource %q in %q: %s", name, orgNs.Name, err))
-				errors = append(errors, err)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, f := range nsFailures {
+				errors = append(errors, fmt.Errorf("unable to apply resource %q to %q: %s", f.Resource, f.Namespace, f.Message))
+			}
+			failures = append(failures, nsFailures...)
+			if reached {
+				rolloutFailures += len(nsFailures)
+			}
+			if len(nsFailures) == 0 {
+				namespacesApplied++
 			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	original := profile.Status.DeepCopy()
+	if dryRunErr == nil {
+		profile.Status.DryRun = dryRun
+	}
+
+	desired, pruneErr := plan.appliedResources()
+	if pruneErr != nil {
+		l.Error(pruneErr, "unable to determine applied resources")
+		errors = append(errors, pruneErr)
+	}
+	// Previously applied resources are tracked until they are successfully pruned.
+	profile.Status.AppliedResources = mergeAppliedResources(desired, profile.Status.AppliedResources)
+	if !r.DisablePruning && pruneErr == nil {
+		pruneErr = r.pruneResources(ctx, profile, desired, orgNamespaces)
+		if pruneErr != nil {
+			l.Error(pruneErr, "unable to prune resources")
+			r.Recorder.Event(&profile, "Warning", "PruneFailed", pruneErr.Error())
+			errors = append(errors, pruneErr)
+		} else {
+			profile.Status.AppliedResources = desired
+		}
+	}
+
+	setApplyStatus(&profile, namespacesApplied, failures, pruneErr)
+	res, rolloutErr := r.progressRollout(ctx, &profile, plan, rolloutFailures, now)
+	if rolloutErr != nil {
+		l.Error(rolloutErr, "unable to complete rollout")
+		errors = append(errors, rolloutErr)
+	}
+	if !equality.Semantic.DeepEqual(original, &profile.Status) {
+		if err := r.Client.Status().Update(ctx, &profile); err != nil {
+			l.Error(err, "unable to update ZoneUsageProfile status")
+			errors = append(errors, err)
+		}
+	}
+
+	return res, multierr.Combine(errors...)
+}
+
+// setApplyStatus sets the observed generation, the applied namespaces, the failures, and the conditions of the ZoneUsageProfile.
+// Failures are sorted and truncated to MaxApplyFailures.
+func setApplyStatus(profile *cloudagentv1.ZoneUsageProfile, namespacesApplied int, failures []cloudagentv1.ApplyFailure, pruneErr error) {
+	slices.SortFunc(failures, func(a, b cloudagentv1.ApplyFailure) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Resource, b.Resource))
+	})
+	failedNamespaces := 0
+	for i := range failures {
+		if i == 0 || failures[i].Namespace != failures[i-1].Namespace {
+			failedNamespaces++
+		}
+	}
+
+	profile.Status.ObservedGeneration = profile.Generation
+	profile.Status.NamespacesApplied = namespacesApplied
+	profile.Status.Failures = failures[:min(len(failures), cloudagentv1.MaxApplyFailures)]
+
+	ready := metav1.Condition{
+		Type:               cloudagentv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: profile.Generation,
+		Reason:             "Applied",
+		Message:            fmt.Sprintf("Applied to %d namespaces", namespacesApplied),
+	}
+	degraded := metav1.Condition{
+		Type:               cloudagentv1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: profile.Generation,
+		Reason:             "Applied",
+		Message:            "All resources applied",
+	}
+	if len(failures) > 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "ApplyFailed"
+		ready.Message = fmt.Sprintf("Failed to apply %d resources to %d namespaces", len(failures), failedNamespaces)
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = ready.Reason
+		degraded.Message = ready.Message
+	} else if pruneErr != nil {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "PruneFailed"
+		degraded.Message = pruneErr.Error()
+	}
+	meta.SetStatusCondition(&profile.Status.Conditions, ready)
+	meta.SetStatusCondition(&profile.Status.Conditions, degraded)
+}
+
+// reconcileNamespace applies the revision of the ZoneUsageProfile selected by the current rollout to the given namespace.
+func (r *ZoneUsageProfileApplyReconciler) reconcileNamespace(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, namespace string) error {
+	var orgNs corev1.Namespace
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: namespace}, &orgNs); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if _, ok := orgNs.Labels[r.OrganizationLabel]; !ok {
+		return nil
+	}
+	if !r.profileAppliesToNamespace(ctx, profile, orgNs) {
+		if r.DisablePruning || orgNs.DeletionTimestamp != nil {
+			return nil
+		}
+		// The namespace might have selected the ZoneUsageProfile before.
+		desired, err := appliedResources(profile)
+		if err != nil {
+			return err
 		}
+		return r.pruneResources(ctx, profile, desired, map[string]bool{orgNs.Name: false}, client.InNamespace(orgNs.Name))
+	}
+
+	plan, err := r.planRollout(ctx, profile, time.Now())
+	if err != nil {
+		return err
 	}
+	nsProfile, _ := plan.profileForNamespace(orgNs)
 
-	return ctrl.Result{}, multierr.Combine(errors...)
+	var errors []error
+	for _, f := range r.applyToNamespace(ctx, nsProfile, orgNs) {
+		errors = append(errors, fmt.Errorf("unable to apply resource %q: %s", f.Resource, f.Message))
+	}
+	return multierr.Combine(errors...)
+}
+
+// profileAppliesToNamespace returns true if the ZoneUsageProfile should be applied to the namespace.
+// Namespaces selecting a different ZoneUsageProfile and namespaces being deleted are skipped.
+func (r *ZoneUsageProfileApplyReconciler) profileAppliesToNamespace(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, orgNs corev1.Namespace) bool {
+	l := log.FromContext(ctx).WithValues("namespace", orgNs.Name)
+	if selected := nsquota.NamespaceProfile(&orgNs, r.UsageProfileLabel, r.SelectedProfile); selected != "" && selected != profile.Name {
+		l.V(1).Info("Skipping Namespace", "reason", "Namespace selects a different ZoneUsageProfile", "selectedProfile", selected)
+		return false
+	}
+	if orgNs.DeletionTimestamp != nil && time.Now().After(orgNs.DeletionTimestamp.Time) {
+		l.Info("Skipping Namespace", "reason", "Namespace is being deleted")
+		return false
+	}
+	return true
+}
+
+// applyToNamespace applies all resources of the ZoneUsageProfile to the namespace.
+// It returns the resources failing to apply.
+func (r *ZoneUsageProfileApplyReconciler) applyToNamespace(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, orgNs corev1.Namespace) []cloudagentv1.ApplyFailure {
+	l := log.FromContext(ctx).WithValues("namespace", orgNs.Name)
+	l.Info("Applying UsageProfile to Namespace")
+
+	var failures []cloudagentv1.ApplyFailure
+	for name, resource := range profile.Spec.UpstreamSpec.Resources {
+		l := l.WithValues("resourceName", name)
+		l.Info("Applying UsageProfile Resource to Namespace")
+
+		if err := r.applyResourceToNamespace(ctx, name, orgNs, resource, profile); err != nil {
+			l.Error(err, "unable to create or update resource")
+			r.Recorder.Event(&profile, "Warning", "ApplyFailed", fmt.Sprintf("unable to create or update resource %q in %q: %s", name, orgNs.Name, err))
+			failures = append(failures, cloudagentv1.ApplyFailure{Namespace: orgNs.Name, Resource: name, Message: err.Error()})
+		}
+	}
+	return failures
+}
+
+// pruneResources deletes resources owned by the ZoneUsageProfile in organization namespaces that are not in the desired resources,
+// or that no longer select the ZoneUsageProfile.
+// Only kinds currently or previously applied by the ZoneUsageProfile are checked.
+func (r *ZoneUsageProfileApplyReconciler) pruneResources(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, desired []cloudagentv1.AppliedResource, orgNamespaces map[string]bool, opts ...client.ListOption) error {
+	l := log.FromContext(ctx)
+
+	toPrune, err := r.pruneCandidates(ctx, profile, desired, orgNamespaces, opts...)
+	errors := []error{err}
+	for _, obj := range toPrune {
+		l.Info("Pruning resource no longer defined in ZoneUsageProfile", "namespace", obj.GetNamespace(), "resourceName", obj.GetName(), "gvk", obj.GroupVersionKind().String())
+		if err := r.Client.Delete(ctx, &obj); client.IgnoreNotFound(err) != nil {
+			errors = append(errors, fmt.Errorf("unable to prune resource %q/%q in %q: %w", obj.GroupVersionKind().String(), obj.GetName(), obj.GetNamespace(), err))
+		}
+	}
+
+	return multierr.Combine(errors...)
+}
+
+// pruneCandidates returns the resources owned by the ZoneUsageProfile in organization namespaces that are not in the desired resources.
+// orgNamespaces maps the organization namespaces to whether the ZoneUsageProfile applies to them.
+// All owned resources are returned for namespaces the ZoneUsageProfile no longer applies to, for example after the organization selected a different profile.
+// Only kinds currently or previously applied by the ZoneUsageProfile are checked, opts are added to the list requests.
+// Kinds failing to list are skipped and the error is returned together with the candidates of the other kinds.
+func (r *ZoneUsageProfileApplyReconciler) pruneCandidates(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, desired []cloudagentv1.AppliedResource, orgNamespaces map[string]bool, opts ...client.ListOption) ([]unstructured.Unstructured, error) {
+	gvks := make(map[schema.GroupVersionKind]struct{})
+	for _, res := range mergeAppliedResources(desired, profile.Status.AppliedResources) {
+		gvks[res.GroupVersionKind()] = struct{}{}
+	}
+
+	var errors []error
+	var candidates []unstructured.Unstructured
+	for gvk := range gvks {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.Client.List(ctx, list, append([]client.ListOption{client.MatchingLabels{resourceOwnerLabel: profile.Name}}, opts...)...); err != nil {
+			if meta.IsNoMatchError(err) {
+				// The kind no longer exists, nothing left to prune.
+				continue
+			}
+			errors = append(errors, fmt.Errorf("unable to list %s: %w", gvk, err))
+			continue
+		}
+		for _, obj := range list.Items {
+			key := cloudagentv1.AppliedResource{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Name: obj.GetName()}
+			applies, isOrgNs := orgNamespaces[obj.GetNamespace()]
+			if !isOrgNs || (applies && slices.Contains(desired, key)) || obj.GetDeletionTimestamp() != nil {
+				continue
+			}
+			obj.SetGroupVersionKind(gvk)
+			candidates = append(candidates, obj)
+		}
+	}
+
+	return candidates, multierr.Combine(errors...)
+}
+
+// appliedResources returns the sorted resources defined in the ZoneUsageProfile.
+func appliedResources(profile cloudagentv1.ZoneUsageProfile) ([]cloudagentv1.AppliedResource, error) {
+	applied := make([]cloudagentv1.AppliedResource, 0, len(profile.Spec.UpstreamSpec.Resources))
+	for name, resource := range profile.Spec.UpstreamSpec.Resources {
+		raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resource)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert resource %q to Unstructured: %w", name, err)
+		}
+		gvk := (&unstructured.Unstructured{Object: raw}).GroupVersionKind()
+		applied = append(applied, cloudagentv1.AppliedResource{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Name: name})
+	}
+	slices.SortFunc(applied, compareAppliedResources)
+	return applied, nil
+}
+
+// mergeAppliedResources returns the sorted union of the given resources.
+func mergeAppliedResources(a, b []cloudagentv1.AppliedResource) []cloudagentv1.AppliedResource {
+	merged := slices.Concat(a, b)
+	slices.SortFunc(merged, compareAppliedResources)
+	return slices.Compact(merged)
+}
+
+func compareAppliedResources(a, b cloudagentv1.AppliedResource) int {
+	return cmp.Or(
+		cmp.Compare(a.Group, b.Group),
+		cmp.Compare(a.Version, b.Version),
+		cmp.Compare(a.Kind, b.Kind),
+		cmp.Compare(a.Name, b.Name),
+	)
 }
 
 // applyResourceToNamespace applies a resource from a ZoneUsageProfile to a namespace.
 // It handles the needed conversions and sets the resourceOwnerLabel.
+// A resource not selected for the namespace is removed from the namespace instead.
 // It returns an error if the resource is already managed by a different ZoneUsageProfile.
 func (r *ZoneUsageProfileApplyReconciler) applyResourceToNamespace(ctx context.Context, name string, orgNs corev1.Namespace, resource runtime.RawExtension, profile cloudagentv1.ZoneUsageProfile) error {
 	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resource)
 	if err != nil {
 		return fmt.Errorf("unable to convert RawExtension to Unstructured: %w", err)
 	}
-	u := &unstructured.Unstructured{}
-	// Set enough information for the GET request to work
-	u.SetGroupVersionKind((&unstructured.Unstructured{Object: raw}).GetObjectKind().GroupVersionKind())
+	u := &unstructured.Unstructured{Object: raw}
 	u.SetNamespace(orgNs.Name)
 	u.SetName(name)
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, u, func() error {
-		lbls := u.GetLabels()
-		if lbls == nil {
-			lbls = make(map[string]string)
-		}
 
-		// Set the full object to be applied, this will overwrite the existing object so we need to re-set the metadata.
-		u.SetUnstructuredContent(raw)
-		u.SetNamespace(orgNs.Name)
-		u.SetName(name)
-		err := controllerutil.SetControllerReference(&profile, u, r.Scheme)
-		if err != nil {
-			return fmt.Errorf("unable to set controller reference: %w", err)
-		}
+	selected, err := resourceSelectedForNamespace(u, orgNs)
+	if err != nil {
+		return err
+	}
+	if selected {
+		err = r.serverSideApply(ctx, u, orgNs, profile)
+	} else {
+		err = r.removeResourceFromNamespace(ctx, u, profile)
+	}
 
-		for _, t := range r.Transformers {
-			if err := t.Transform(ctx, u, &orgNs); err != nil {
-				log.FromContext(ctx).Error(err, "unable to fully transform object")
-			}
+	if err := r.ensureWatch(ctx, u.GetObjectKind().GroupVersionKind()); err != nil {
+		return fmt.Errorf("unable to watch, object might not be reconciled: %w", err)
+	}
+
+	return err
+}
+
+// removeResourceFromNamespace deletes the given object if it is managed by the ZoneUsageProfile.
+// Nothing is deleted if pruning is disabled.
+func (r *ZoneUsageProfileApplyReconciler) removeResourceFromNamespace(ctx context.Context, u *unstructured.Unstructured, profile cloudagentv1.ZoneUsageProfile) error {
+	if r.DisablePruning {
+		return nil
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(u.GroupVersionKind())
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(u), existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if existing.GetLabels()[resourceOwnerLabel] != profile.Name || existing.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	log.FromContext(ctx).Info("Removing resource not selected for namespace", "namespace", u.GetNamespace(), "resourceName", u.GetName())
+	if err := r.Client.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to remove resource: %w", err)
+	}
+	return nil
+}
+
+// resourceSelectedForNamespace returns true if the resource of a ZoneUsageProfile should be applied to the namespace.
+// Resources are not selected if they have a resourceNamespaceSelectorAnnotation not matching the labels of the namespace,
+// or if the namespace lists the resource in the namespaceOptOutAnnotation.
+func resourceSelectedForNamespace(u *unstructured.Unstructured, ns corev1.Namespace) (bool, error) {
+	for _, optOut := range strings.Split(ns.Annotations[namespaceOptOutAnnotation], ",") {
+		if strings.TrimSpace(optOut) == u.GetName() {
+			return false, nil
 		}
+	}
 
-		p, exists := lbls[resourceOwnerLabel]
-		if exists && p != profile.Name {
-			return fmt.Errorf("conflict: resource %q/%q in %q already has a different UsageProfile applied: %s", u.GetObjectKind().GroupVersionKind().String(), name, orgNs.Name, p)
+	rawSelector, ok := u.GetAnnotations()[resourceNamespaceSelectorAnnotation]
+	if !ok {
+		return true, nil
+	}
+	selector, err := labels.Parse(rawSelector)
+	if err != nil {
+		return false, fmt.Errorf("unable to parse namespace selector of resource %q: %w", u.GetName(), err)
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// serverSideApply applies the given object with the usageProfileFieldManager.
+// Only the fields defined in the ZoneUsageProfile are managed, fields set by other managers are kept.
+// Fields conflicting with other managers are reported as an event and taken over.
+func (r *ZoneUsageProfileApplyReconciler) serverSideApply(ctx context.Context, u *unstructured.Unstructured, orgNs corev1.Namespace, profile cloudagentv1.ZoneUsageProfile) error {
+	existing, err := r.prepareResource(ctx, u, orgNs, profile)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if err := r.upgradeManagedFields(ctx, existing); err != nil {
+			return err
 		}
-		lbls[resourceOwnerLabel] = profile.Name
-		u.SetLabels(lbls)
+	}
+
+	err = r.Client.Patch(ctx, u, client.Apply, client.FieldOwner(usageProfileFieldManager))
+	if apierrors.IsConflict(err) {
+		log.FromContext(ctx).Info("Taking over conflicting fields", "namespace", orgNs.Name, "resourceName", u.GetName(), "conflict", err.Error())
+		r.Recorder.Event(&profile, "Warning", "FieldConflict", fmt.Sprintf("taking over fields of resource %q in %q from other managers: %s", u.GetName(), orgNs.Name, err))
+		err = r.Client.Patch(ctx, u, client.Apply, client.FieldOwner(usageProfileFieldManager), client.ForceOwnership)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to apply resource: %w", err)
+	}
+	return nil
+}
+
+// upgradeManagedFields transfers the fields of the given object updated by the legacyFieldManager to the usageProfileFieldManager.
+// Without the upgrade, fields removed from the ZoneUsageProfile would be kept, since they are still owned by the legacyFieldManager.
+// Objects without fields of the legacyFieldManager are not modified.
+func (r *ZoneUsageProfileApplyReconciler) upgradeManagedFields(ctx context.Context, existing *unstructured.Unstructured) error {
+	upgraded := existing.DeepCopy()
+	if err := csaupgrade.UpgradeManagedFields(upgraded, sets.New(legacyFieldManager), usageProfileFieldManager); err != nil {
+		return fmt.Errorf("unable to upgrade managed fields: %w", err)
+	}
+	if equality.Semantic.DeepEqual(existing.GetManagedFields(), upgraded.GetManagedFields()) {
 		return nil
-	})
+	}
+	log.FromContext(ctx).Info("Upgrading managed fields for server-side apply", "namespace", existing.GetNamespace(), "resourceName", existing.GetName())
+	if err := r.Client.Patch(ctx, upgraded, client.MergeFromWithOptions(existing, client.MergeFromWithOptimisticLock{})); err != nil {
+		return fmt.Errorf("unable to upgrade managed fields: %w", err)
+	}
+	return nil
+}
 
-	if err := r.ensureWatch(ctx, u.GetObjectKind().GroupVersionKind()); err != nil {
-		return fmt.Errorf("unable to watch, object might not be reconciled: %w", err)
+// prepareResource sets the controller reference and the resourceOwnerLabel and runs the transformers on the given object.
+// It returns the existing object, or nil if the object does not exist.
+// It returns an error if a transformer fails to render the object.
+// It returns an error if the resource is already managed by a different ZoneUsageProfile.
+func (r *ZoneUsageProfileApplyReconciler) prepareResource(ctx context.Context, u *unstructured.Unstructured, orgNs corev1.Namespace, profile cloudagentv1.ZoneUsageProfile) (*unstructured.Unstructured, error) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(u.GroupVersionKind())
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(u), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to get resource: %w", err)
+		}
+		existing = nil
+	}
+	// A profile selected by the namespace takes over resources of previously selected profiles.
+	if existing != nil {
+		p, exists := existing.GetLabels()[resourceOwnerLabel]
+		if exists && p != profile.Name && nsquota.NamespaceProfile(&orgNs, r.UsageProfileLabel, r.SelectedProfile) == "" {
+			return nil, fmt.Errorf("conflict: resource %q/%q in %q already has a different UsageProfile applied: %s", u.GetObjectKind().GroupVersionKind().String(), u.GetName(), orgNs.Name, p)
+		}
 	}
 
-	return err
+	if err := controllerutil.SetControllerReference(&profile, u, r.Scheme); err != nil {
+		return nil, fmt.Errorf("unable to set controller reference: %w", err)
+	}
+	for _, t := range r.Transformers {
+		if err := t.Transform(ctx, u, &orgNs); err != nil {
+			if errors.Is(err, transformers.ErrRender) {
+				return nil, err
+			}
+			log.FromContext(ctx).Error(err, "unable to fully transform object")
+		}
+	}
+	lbls := u.GetLabels()
+	if lbls == nil {
+		lbls = make(map[string]string)
+	}
+	lbls[resourceOwnerLabel] = profile.Name
+	u.SetLabels(lbls)
+
+	return existing, nil
 }
 
 // ensureWatch ensures that the given GroupVersionKind is watched exactly once by the controller.
@@ -173,7 +612,7 @@ func (r *ZoneUsageProfileApplyReconciler) ensureWatch(ctx context.Context, gvk s
 		toWatch := &unstructured.Unstructured{}
 		toWatch.SetGroupVersionKind(gvk)
 
-		err = r.controller.Watch(source.Kind[client.Object](r.Cache, toWatch, handler.EnqueueRequestForOwner(r.Scheme, r.Client.RESTMapper(), &cloudagentv1.ZoneUsageProfile{})))
+		err = r.controller.Watch(source.Kind[client.Object](r.Cache, toWatch, handler.EnqueueRequestsFromMapFunc(mapToOwningUsageProfile)))
 	})
 	return err
 }
@@ -186,12 +625,15 @@ func (r *ZoneUsageProfileApplyReconciler) SetupWithManager(mgr ctrl.Manager) err
 	}
 
 	c, err := ctrl.NewControllerManagedBy(mgr).
-		For(&cloudagentv1.ZoneUsageProfile{}).
+		// Status updates of the reconciler must not trigger another reconcile.
+		// Annotation changes are needed for the dry run and rollback annotations.
+		For(&cloudagentv1.ZoneUsageProfile{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Named("zoneusageprofiles_apply").
-		// Watch all namespaces and enqueue requests for all profiles on any change.
+		// Watch all namespaces and enqueue requests for all profiles restricted to the changed namespace.
 		Watches(
 			&corev1.Namespace{},
-			handler.EnqueueRequestsFromMapFunc(mapToAllUsageProfiles(mgr.GetClient())),
+			handler.EnqueueRequestsFromMapFunc(mapNamespaceToAllUsageProfiles(mgr.GetClient())),
 			builder.WithPredicates(orgPredicate)).
 		Build(r)
 	if err != nil {
@@ -210,9 +652,15 @@ func labelExistsPredicate(label string) (predicate.Predicate, error) {
 		}}})
 }
 
-// mapToAllUsageProfiles returns a MapFunc that enqueues reconcile requests for all ZoneUsageProfiles on every event.
-func mapToAllUsageProfiles(cl client.Client) handler.MapFunc {
-	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+// namespaceRequest returns a request applying the ZoneUsageProfile only to the given namespace.
+// ZoneUsageProfiles are cluster scoped, the namespace of the request is used to restrict the reconciliation to a single namespace.
+func namespaceRequest(profile, namespace string) reconcile.Request {
+	return reconcile.Request{NamespacedName: client.ObjectKey{Name: profile, Namespace: namespace}}
+}
+
+// mapNamespaceToAllUsageProfiles returns a MapFunc that enqueues reconcile requests for all ZoneUsageProfiles restricted to the namespace of the event.
+func mapNamespaceToAllUsageProfiles(cl client.Client) handler.MapFunc {
+	return func(ctx context.Context, ns client.Object) []reconcile.Request {
 		var profiles cloudagentv1.ZoneUsageProfileList
 		if err := cl.List(ctx, &profiles); err != nil {
 			log.FromContext(ctx).Error(err, "unable to list ZoneUsageProfiles")
@@ -220,8 +668,18 @@ func mapToAllUsageProfiles(cl client.Client) handler.MapFunc {
 		}
 		reqs := make([]reconcile.Request, 0, len(profiles.Items))
 		for _, profile := range profiles.Items {
-			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKey{Name: profile.Name}})
+			reqs = append(reqs, namespaceRequest(profile.Name, ns.GetName()))
 		}
 		return reqs
 	}
 }
+
+// mapToOwningUsageProfile enqueues a reconcile request for the ZoneUsageProfile in the resourceOwnerLabel of the object,
+// restricted to the namespace of the object.
+func mapToOwningUsageProfile(_ context.Context, obj client.Object) []reconcile.Request {
+	profile, ok := obj.GetLabels()[resourceOwnerLabel]
+	if !ok || obj.GetNamespace() == "" {
+		return nil
+	}
+	return []reconcile.Request{namespaceRequest(profile, obj.GetNamespace())}
+}
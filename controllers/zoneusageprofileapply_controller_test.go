@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"regexp"
 	"testing"
 	"time"
@@ -10,16 +11,27 @@ import (
 	"github.com/go-logr/logr/testr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
 	"github.com/appuio/appuio-cloud-agent/controllers/transformers"
@@ -116,6 +128,404 @@ func Test_ZoneUsageProfileApplyReconciler_Reconcile(t *testing.T) {
 	assert.Contains(t, <-recorder.Events, "conflict", regexp.MustCompile(`^Warning.*conflict`))
 }
 
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_SelectedProfile(t *testing.T) {
+	const orgLbl = "test.com/organization"
+	const profileLbl = "test.com/usage-profile"
+
+	_, scheme, _ := prepareClient(t)
+	subject, c := newApplyReconciler(t,
+		newNamespace("default-profile", map[string]string{orgLbl: "foo"}, nil),
+		newNamespace("selects-large", map[string]string{orgLbl: "bar", profileLbl: "large"}, nil),
+		newNamespace("selects-default", map[string]string{orgLbl: "baz", profileLbl: "default"}, nil),
+		buildUsageProfile(t, scheme, "default"),
+		buildUsageProfile(t, scheme, "large"),
+	)
+	subject.SelectedProfile = "default"
+	subject.UsageProfileLabel = profileLbl
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "default"}})
+	require.NoError(t, err)
+	_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "large"}})
+	require.NoError(t, err)
+
+	for ns, profile := range map[string]string{
+		"default-profile": "default",
+		"selects-large":   "large",
+		"selects-default": "default",
+	} {
+		quota := &corev1.ResourceQuota{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: ns}, quota))
+		assert.Equal(t, profile, quota.Labels[resourceOwnerLabel], ns)
+	}
+
+	// Switching the profile of a namespace takes over the resources of the previous profile.
+	ns := &corev1.Namespace{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "selects-default"}, ns))
+	ns.Labels[profileLbl] = "large"
+	require.NoError(t, c.Update(context.Background(), ns))
+	_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "large"}})
+	require.NoError(t, err)
+
+	quota := &corev1.ResourceQuota{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "selects-default"}, quota))
+	assert.Equal(t, "large", quota.Labels[resourceOwnerLabel])
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_SwitchProfile(t *testing.T) {
+	const orgLbl = "test.com/organization"
+	const profileLbl = "test.com/usage-profile"
+
+	_, scheme, _ := prepareClient(t)
+	small := buildUsageProfile(t, scheme, "small")
+	small.Spec.UpstreamSpec.Resources["org-limits"] = runtime.RawExtension{
+		Object: ensureGVK(t, scheme, &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: "test"}}),
+	}
+	subject, c := newApplyReconciler(t,
+		newNamespace("org-a", map[string]string{orgLbl: "foo", profileLbl: "small"}, nil),
+		newNamespace("org-b", map[string]string{orgLbl: "foo", profileLbl: "small"}, nil),
+		small,
+		buildUsageProfile(t, scheme, "large"),
+	)
+	subject.UsageProfileLabel = profileLbl
+	reconcileProfile := func(req reconcile.Request) {
+		t.Helper()
+		_, err := subject.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+	}
+	assertLimitRange := func(ns string, exists bool) {
+		t.Helper()
+		err := c.Get(context.Background(), types.NamespacedName{Name: "org-limits", Namespace: ns}, &corev1.LimitRange{})
+		if exists {
+			assert.NoError(t, err, ns)
+			return
+		}
+		assert.True(t, apierrors.IsNotFound(err), "should prune resources of the previous profile in %s", ns)
+	}
+
+	reconcileProfile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "small"}})
+	assertLimitRange("org-a", true)
+	assertLimitRange("org-b", true)
+
+	// The organization switches from profile small to large
+	for _, name := range []string{"org-a", "org-b"} {
+		ns := &corev1.Namespace{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: name}, ns))
+		ns.Labels[profileLbl] = "large"
+		require.NoError(t, c.Update(context.Background(), ns))
+	}
+
+	// Namespace requests prune the resources of the previous profile
+	reconcileProfile(namespaceRequest("small", "org-a"))
+	assertLimitRange("org-a", false)
+	assertLimitRange("org-b", true)
+
+	// The new profile takes over the resources it defines
+	reconcileProfile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "large"}})
+	// Full requests prune the resources of the previous profile in all namespaces
+	reconcileProfile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "small"}})
+	assertLimitRange("org-b", false)
+	for _, ns := range []string{"org-a", "org-b"} {
+		quota := &corev1.ResourceQuota{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: ns}, quota))
+		assert.Equal(t, "large", quota.Labels[resourceOwnerLabel], "should keep resources taken over by the new profile in %s", ns)
+	}
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_Prune(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	for _, disablePruning := range []bool{false, true} {
+		t.Run(fmt.Sprintf("DisablePruning=%t", disablePruning), func(t *testing.T) {
+			_, scheme, _ := prepareClient(t)
+			profile := buildUsageProfile(t, scheme, "test")
+			profile.Spec.UpstreamSpec.Resources["org-limits"] = runtime.RawExtension{
+				Object: ensureGVK(t, scheme, &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: "test"}}),
+			}
+			subject, c := newApplyReconciler(t,
+				newNamespace("org", map[string]string{orgLbl: "foo"}, nil),
+				newNamespace("not-org", nil, nil),
+				&corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: "org-limits", Namespace: "not-org", Labels: map[string]string{resourceOwnerLabel: "test"}}},
+				profile,
+			)
+			subject.DisablePruning = disablePruning
+
+			_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+			require.NoError(t, err)
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-limits", Namespace: "org"}, &corev1.LimitRange{}))
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+			applied := []cloudagentv1.AppliedResource{
+				{Version: "v1", Kind: "LimitRange", Name: "org-limits"},
+				{Version: "v1", Kind: "ResourceQuota", Name: "org-usage"},
+			}
+			assert.Equal(t, applied, profile.Status.AppliedResources)
+
+			delete(profile.Spec.UpstreamSpec.Resources, "org-limits")
+			require.NoError(t, c.Update(context.Background(), profile))
+			_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+			require.NoError(t, err)
+
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org"}, &corev1.ResourceQuota{}))
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-limits", Namespace: "not-org"}, &corev1.LimitRange{}), "should not prune outside of organization namespaces")
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+			err = c.Get(context.Background(), types.NamespacedName{Name: "org-limits", Namespace: "org"}, &corev1.LimitRange{})
+			if disablePruning {
+				require.NoError(t, err, "should not prune if pruning is disabled")
+				assert.Equal(t, applied, profile.Status.AppliedResources, "should keep tracking unpruned resources")
+				return
+			}
+			require.True(t, apierrors.IsNotFound(err), "should prune resources removed from the profile")
+			assert.Equal(t, applied[1:], profile.Status.AppliedResources)
+		})
+	}
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_Status(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	profile := buildUsageProfile(t, scheme, "test")
+	profile.Generation = 3
+	conflicting := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "org-usage", Namespace: "org2", Labels: map[string]string{resourceOwnerLabel: "other"}}}
+	subject, c := newApplyReconciler(t,
+		newNamespace("org1", map[string]string{orgLbl: "foo"}, nil),
+		newNamespace("org2", map[string]string{orgLbl: "bar"}, nil),
+		conflicting,
+		profile,
+	)
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.ErrorContains(t, err, "conflict")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Equal(t, int64(3), profile.Status.ObservedGeneration)
+	assert.Equal(t, 1, profile.Status.NamespacesApplied)
+	require.Len(t, profile.Status.Failures, 1)
+	assert.Equal(t, "org2", profile.Status.Failures[0].Namespace)
+	assert.Equal(t, "org-usage", profile.Status.Failures[0].Resource)
+	assert.Contains(t, profile.Status.Failures[0].Message, "conflict")
+	assert.True(t, apimeta.IsStatusConditionFalse(profile.Status.Conditions, cloudagentv1.ConditionReady))
+	assert.True(t, apimeta.IsStatusConditionTrue(profile.Status.Conditions, cloudagentv1.ConditionDegraded))
+
+	require.NoError(t, c.Delete(context.Background(), conflicting))
+	_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Equal(t, 2, profile.Status.NamespacesApplied)
+	assert.Empty(t, profile.Status.Failures)
+	assert.True(t, apimeta.IsStatusConditionTrue(profile.Status.Conditions, cloudagentv1.ConditionReady))
+	assert.True(t, apimeta.IsStatusConditionFalse(profile.Status.Conditions, cloudagentv1.ConditionDegraded))
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_RenderFailure(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	profile := buildUsageProfile(t, scheme, "test")
+	profile.Spec.UpstreamSpec.Resources["org-info"] = runtime.RawExtension{
+		Object: ensureGVK(t, scheme, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{transformers.TemplateAnnotation: "true"}},
+			Data:       map[string]string{"organization": "{{ .Organization"},
+		}),
+	}
+	subject, c := newApplyReconciler(t,
+		newNamespace("org", map[string]string{orgLbl: "foo"}, nil),
+		profile,
+	)
+	subject.Transformers = []transformers.Transformer{transformers.NewTemplateTransformer(orgLbl, "zone")}
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.ErrorContains(t, err, "unable to render object")
+
+	err = c.Get(context.Background(), types.NamespacedName{Name: "org-info", Namespace: "org"}, &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err), "should not apply resources failing to render")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org"}, &corev1.ResourceQuota{}))
+
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	require.Len(t, profile.Status.Failures, 1)
+	assert.Equal(t, "org", profile.Status.Failures[0].Namespace)
+	assert.Equal(t, "org-info", profile.Status.Failures[0].Resource)
+	assert.Contains(t, profile.Status.Failures[0].Message, ".data.organization")
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_FieldConflict(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	subject, c := newApplyReconciler(t,
+		newNamespace("org", map[string]string{orgLbl: "foo"}, nil),
+		buildUsageProfile(t, scheme, "test"),
+	)
+	var forced bool
+	subject.Client = interceptor.NewClient(c, interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			po := &client.PatchOptions{}
+			po.ApplyOptions(opts)
+			assert.Equal(t, usageProfileFieldManager, po.FieldManager)
+			if po.Force == nil || !*po.Force {
+				return apierrors.NewConflict(schema.GroupResource{Resource: "resourcequotas"}, obj.GetName(), fmt.Errorf("conflict with \"other-manager\": .spec.hard.cpu"))
+			}
+			forced = true
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	recorder := subject.Recorder.(*record.FakeRecorder)
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+	assert.True(t, forced, "should take over conflicting fields")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org"}, &corev1.ResourceQuota{}))
+	require.Len(t, recorder.Events, 1)
+	assert.Regexp(t, `^Warning FieldConflict .*other-manager`, <-recorder.Events)
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_UpgradeManagedFields(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	subject, c := newApplyReconciler(t,
+		newNamespace("org", map[string]string{orgLbl: "foo"}, nil),
+		buildUsageProfile(t, scheme, "test"),
+	)
+	c = interceptor.NewClient(c, fieldManagedServerSideApply(t))
+	subject.Client = c
+
+	// Previous versions created and updated the resources without server-side apply.
+	legacy := &unstructured.Unstructured{}
+	legacy.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ResourceQuota"))
+	legacy.SetNamespace("org")
+	legacy.SetName("org-usage")
+	legacy.SetLabels(map[string]string{resourceOwnerLabel: "test"})
+	require.NoError(t, unstructured.SetNestedStringMap(legacy.Object, map[string]string{"cpu": "666", "memory": "1Gi"}, "spec", "hard"))
+	require.NoError(t, c.Create(context.Background(), legacy, client.FieldOwner(legacyFieldManager)))
+	require.Len(t, legacy.GetManagedFields(), 1)
+	require.Equal(t, metav1.ManagedFieldsOperationUpdate, legacy.GetManagedFields()[0].Operation)
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+
+	quota := &corev1.ResourceQuota{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org"}, quota))
+	assert.Equal(t, "666", quota.Spec.Hard.Cpu().String())
+	assert.NotContains(t, quota.Spec.Hard, corev1.ResourceMemory, "should remove fields set by the legacy field manager but not defined in the profile")
+	for _, mf := range quota.ManagedFields {
+		assert.NotEqual(t, legacyFieldManager, mf.Manager, "should transfer the fields of the legacy field manager")
+	}
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_NamespaceSelector(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	profile := buildUsageProfile(t, scheme, "test")
+	profile.Spec.UpstreamSpec.Resources["preview-limits"] = runtime.RawExtension{
+		Object: ensureGVK(t, scheme, &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{resourceNamespaceSelectorAnnotation: "test.com/preview=true"},
+		}}),
+	}
+	subject, c := newApplyReconciler(t,
+		newNamespace("prod", map[string]string{orgLbl: "foo"}, nil),
+		newNamespace("preview", map[string]string{orgLbl: "foo", "test.com/preview": "true"}, nil),
+		newNamespace("opt-out", map[string]string{orgLbl: "foo", "test.com/preview": "true"}, map[string]string{namespaceOptOutAnnotation: "preview-limits, org-usage"}),
+		profile,
+	)
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "prod"}, &corev1.ResourceQuota{}))
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), types.NamespacedName{Name: "preview-limits", Namespace: "prod"}, &corev1.LimitRange{})), "should only apply selected resources")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "preview"}, &corev1.ResourceQuota{}))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "preview-limits", Namespace: "preview"}, &corev1.LimitRange{}))
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "opt-out"}, &corev1.ResourceQuota{})), "should honor opt-out annotation")
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), types.NamespacedName{Name: "preview-limits", Namespace: "opt-out"}, &corev1.LimitRange{})), "should honor opt-out annotation")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Equal(t, 3, profile.Status.NamespacesApplied)
+
+	// Namespace no longer matches the selector
+	ns := &corev1.Namespace{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "preview"}, ns))
+	delete(ns.Labels, "test.com/preview")
+	require.NoError(t, c.Update(context.Background(), ns))
+	_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), types.NamespacedName{Name: "preview-limits", Namespace: "preview"}, &corev1.LimitRange{})), "should clean up resources no longer selected")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "preview"}, &corev1.ResourceQuota{}))
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_Namespace(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	objs := []client.Object{buildUsageProfile(t, scheme, "test")}
+	for i := range 20 {
+		objs = append(objs, newNamespace(fmt.Sprintf("org-%02d", i), map[string]string{orgLbl: "foo"}, nil))
+	}
+	subject, c := newApplyReconciler(t, objs...)
+	subject.MaxConcurrentNamespaces = 4
+	subject.RateLimiter = rate.NewLimiter(rate.Inf, 1)
+
+	_, err := subject.Reconcile(context.Background(), namespaceRequest("test", "org-03"))
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org-03"}, &corev1.ResourceQuota{}))
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org-04"}, &corev1.ResourceQuota{})), "should only apply to the namespace of the request")
+	profile := &cloudagentv1.ZoneUsageProfile{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Empty(t, profile.Status.Conditions, "should not update status for namespace requests")
+
+	_, err = subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.NoError(t, err)
+	var quotas corev1.ResourceQuotaList
+	require.NoError(t, c.List(context.Background(), &quotas))
+	assert.Len(t, quotas.Items, 20, "should apply to all namespaces")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Equal(t, 20, profile.Status.NamespacesApplied)
+}
+
+func Test_setApplyStatus(t *testing.T) {
+	profile := &cloudagentv1.ZoneUsageProfile{}
+	failures := make([]cloudagentv1.ApplyFailure, 0, 15)
+	for i := 15; i > 0; i-- {
+		failures = append(failures, cloudagentv1.ApplyFailure{Namespace: fmt.Sprintf("ns-%02d", i), Resource: "quota", Message: "failed"})
+	}
+
+	setApplyStatus(profile, 5, failures, nil)
+	require.Len(t, profile.Status.Failures, cloudagentv1.MaxApplyFailures, "should bound the reported failures")
+	assert.Equal(t, "ns-01", profile.Status.Failures[0].Namespace, "should sort the failures")
+	ready := apimeta.FindStatusCondition(profile.Status.Conditions, cloudagentv1.ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, "Failed to apply 15 resources to 15 namespaces", ready.Message)
+
+	setApplyStatus(profile, 20, nil, fmt.Errorf("prune failed"))
+	assert.Empty(t, profile.Status.Failures)
+	assert.True(t, apimeta.IsStatusConditionTrue(profile.Status.Conditions, cloudagentv1.ConditionReady))
+	degraded := apimeta.FindStatusCondition(profile.Status.Conditions, cloudagentv1.ConditionDegraded)
+	require.NotNil(t, degraded)
+	assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+	assert.Equal(t, "PruneFailed", degraded.Reason)
+}
+
+// newApplyReconciler returns a ZoneUsageProfileApplyReconciler for namespaces with the test.com/organization label
+// and the fake client it uses, initialized with the given objects.
+// The client emulates server-side apply and the dynamic watch is stubbed.
+func newApplyReconciler(t *testing.T, objs ...client.Object) (*ZoneUsageProfileApplyReconciler, client.WithWatch) {
+	t.Helper()
+
+	_, scheme, _ := prepareClient(t)
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&cloudagentv1.ZoneUsageProfile{}).
+		WithInterceptorFuncs(fakeServerSideApply()).
+		WithObjects(objs...).
+		Build()
+	return &ZoneUsageProfileApplyReconciler{
+		Client:     c,
+		Scheme:     scheme,
+		Recorder:   record.NewFakeRecorder(20),
+		Cache:      &informertest.FakeInformers{},
+		controller: watchStubController{},
+
+		OrganizationLabel: "test.com/organization",
+	}, c
+}
+
 func requireEventually(t *testing.T, f func(collect *assert.CollectT), msgAndArgs ...interface{}) {
 	t.Helper()
 	require.EventuallyWithT(t, f, 10*time.Second, time.Second/10, msgAndArgs...)
@@ -142,7 +552,7 @@ func Test_labelExistsPredicate(t *testing.T) {
 	}))
 }
 
-func Test_mapToAllUsageProfiles(t *testing.T) {
+func Test_mapNamespaceToAllUsageProfiles(t *testing.T) {
 	c, _, _ := prepareClient(t,
 		&cloudagentv1.ZoneUsageProfile{
 			ObjectMeta: metav1.ObjectMeta{
@@ -156,17 +566,25 @@ func Test_mapToAllUsageProfiles(t *testing.T) {
 		},
 	)
 
-	subject := mapToAllUsageProfiles(c)
+	subject := mapNamespaceToAllUsageProfiles(c)
 	assert.ElementsMatch(t,
 		[]reconcile.Request{
-			{NamespacedName: types.NamespacedName{Name: "test1"}},
-			{NamespacedName: types.NamespacedName{Name: "test2"}},
+			{NamespacedName: types.NamespacedName{Name: "test1", Namespace: "org"}},
+			{NamespacedName: types.NamespacedName{Name: "test2", Namespace: "org"}},
 		},
-		subject(context.Background(), &corev1.Namespace{}),
-		"should map any event to all UsageProfiles",
+		subject(context.Background(), newNamespace("org", nil, nil)),
+		"should map any event to all UsageProfiles restricted to the namespace",
 	)
 }
 
+func Test_mapToOwningUsageProfile(t *testing.T) {
+	assert.Equal(t,
+		[]reconcile.Request{{NamespacedName: types.NamespacedName{Name: "test", Namespace: "org"}}},
+		mapToOwningUsageProfile(context.Background(), &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "org-usage", Namespace: "org", Labels: map[string]string{resourceOwnerLabel: "test"}}}),
+	)
+	assert.Empty(t, mapToOwningUsageProfile(context.Background(), &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "org-usage", Namespace: "org"}}))
+}
+
 // buildUsageProfile builds a valid ZoneUsageProfile with a ResourceQuota named test.
 func buildUsageProfile(t *testing.T, scheme *runtime.Scheme, name string) *cloudagentv1.ZoneUsageProfile {
 	t.Helper()
@@ -194,6 +612,13 @@ func buildUsageProfile(t *testing.T, scheme *runtime.Scheme, name string) *cloud
 	}
 }
 
+// watchStubController accepts any watch without starting it.
+type watchStubController struct {
+	controller.Controller
+}
+
+func (watchStubController) Watch(source.Source) error { return nil }
+
 // take the name from the namespace and adds it as an annotation
 type addTestAnnotationTransformer struct{}
 
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+)
+
+// dryRunAnnotation is the annotation on ZoneUsageProfiles enabling the dry run mode.
+// ZoneUsageProfiles with the annotation set to "true" are reconciled as usual,
+// the changes each reconcile is about to make are dry run before applying them and reported in the status.
+// Use the usage-profile-diff subcommand to report the changes without applying them.
+const dryRunAnnotation = "cloud-agent.appuio.io/dry-run"
+
+// isDryRun returns true if the ZoneUsageProfile has the dryRunAnnotation set to "true".
+func isDryRun(profile cloudagentv1.ZoneUsageProfile) bool {
+	return profile.Annotations[dryRunAnnotation] == "true"
+}
+
+// statusDryRun returns the dry run of the plan to report in the status of the ZoneUsageProfile.
+// It returns nil if the ZoneUsageProfile does not have the dryRunAnnotation.
+// The changes and failures are truncated to MaxDryRunChanges and MaxApplyFailures.
+func (r *ZoneUsageProfileApplyReconciler) statusDryRun(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, plan rolloutPlan) (*cloudagentv1.ZoneUsageProfileDryRun, error) {
+	if !isDryRun(profile) {
+		return nil, nil
+	}
+	dryRun, err := r.dryRunPlan(ctx, profile, plan)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dry run ZoneUsageProfile: %w", err)
+	}
+	dryRun.Changes = dryRun.Changes[:min(len(dryRun.Changes), cloudagentv1.MaxDryRunChanges)]
+	dryRun.Failures = dryRun.Failures[:min(len(dryRun.Failures), cloudagentv1.MaxApplyFailures)]
+	return &dryRun, nil
+}
+
+// DryRun returns the changes applying the ZoneUsageProfile would make to the namespaces of the organizations.
+// Each namespace is diffed against the revision the current stage of the rollout of the ZoneUsageProfile assigns to it, see planRollout.
+// The changes are computed with server-side dry run applies, nothing is mutated.
+// Resources failing to dry run are reported as failures.
+// The changes and failures are sorted by namespace and resource.
+func (r *ZoneUsageProfileApplyReconciler) DryRun(ctx context.Context, profile cloudagentv1.ZoneUsageProfile) (cloudagentv1.ZoneUsageProfileDryRun, error) {
+	plan, err := r.planRollout(ctx, profile, time.Now())
+	if err != nil {
+		return cloudagentv1.ZoneUsageProfileDryRun{ObservedGeneration: profile.Generation}, fmt.Errorf("unable to plan rollout: %w", err)
+	}
+	return r.dryRunPlan(ctx, profile, plan)
+}
+
+// dryRunPlan returns the changes applying the revisions of the plan would make to the namespaces of the organizations.
+func (r *ZoneUsageProfileApplyReconciler) dryRunPlan(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, plan rolloutPlan) (cloudagentv1.ZoneUsageProfileDryRun, error) {
+	dryRun := cloudagentv1.ZoneUsageProfileDryRun{ObservedGeneration: profile.Generation}
+
+	var orgNsl corev1.NamespaceList
+	if err := r.Client.List(ctx, &orgNsl, client.HasLabels{r.OrganizationLabel}); err != nil {
+		return dryRun, fmt.Errorf("unable to list namespaces: %w", err)
+	}
+
+	orgNamespaces := make(map[string]bool, len(orgNsl.Items))
+	for _, orgNs := range orgNsl.Items {
+		applies := r.profileAppliesToNamespace(ctx, profile, orgNs)
+		orgNamespaces[orgNs.Name] = applies
+		if !applies {
+			continue
+		}
+		nsProfile, _ := plan.profileForNamespace(orgNs)
+		for _, name := range slices.Sorted(maps.Keys(nsProfile.Spec.UpstreamSpec.Resources)) {
+			change, err := r.dryRunResource(ctx, name, orgNs, nsProfile.Spec.UpstreamSpec.Resources[name], nsProfile)
+			if err != nil {
+				dryRun.Failures = append(dryRun.Failures, cloudagentv1.ApplyFailure{Namespace: orgNs.Name, Resource: name, Message: err.Error()})
+				continue
+			}
+			if change != nil {
+				dryRun.Changes = append(dryRun.Changes, *change)
+			}
+		}
+	}
+
+	if !r.DisablePruning {
+		desired, err := plan.appliedResources()
+		if err != nil {
+			return dryRun, err
+		}
+		toPrune, err := r.pruneCandidates(ctx, profile, desired, orgNamespaces)
+		if err != nil {
+			return dryRun, err
+		}
+		for _, obj := range toPrune {
+			change, err := deleteChange(&obj)
+			if err != nil {
+				return dryRun, err
+			}
+			dryRun.Changes = append(dryRun.Changes, change)
+		}
+	}
+
+	for _, c := range dryRun.Changes {
+		switch c.Action {
+		case cloudagentv1.DryRunActionCreate:
+			dryRun.Creates++
+		case cloudagentv1.DryRunActionUpdate:
+			dryRun.Updates++
+		case cloudagentv1.DryRunActionDelete:
+			dryRun.Deletes++
+		}
+	}
+	slices.SortFunc(dryRun.Changes, func(a, b cloudagentv1.DryRunChange) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Resource, b.Resource), cmp.Compare(a.Kind, b.Kind))
+	})
+	slices.SortFunc(dryRun.Failures, func(a, b cloudagentv1.ApplyFailure) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Resource, b.Resource))
+	})
+	return dryRun, nil
+}
+
+// dryRunResource returns the change applying the resource to the namespace would make.
+// Returns nil if the resource would not change.
+func (r *ZoneUsageProfileApplyReconciler) dryRunResource(ctx context.Context, name string, orgNs corev1.Namespace, resource runtime.RawExtension, profile cloudagentv1.ZoneUsageProfile) (*cloudagentv1.DryRunChange, error) {
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resource)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert RawExtension to Unstructured: %w", err)
+	}
+	u := &unstructured.Unstructured{Object: raw}
+	u.SetNamespace(orgNs.Name)
+	u.SetName(name)
+
+	selected, err := resourceSelectedForNamespace(u, orgNs)
+	if err != nil {
+		return nil, err
+	}
+	if !selected {
+		if r.DisablePruning {
+			return nil, nil
+		}
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(u.GroupVersionKind())
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(u), existing); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		if existing.GetLabels()[resourceOwnerLabel] != profile.Name || existing.GetDeletionTimestamp() != nil {
+			return nil, nil
+		}
+		change, err := deleteChange(existing)
+		return &change, err
+	}
+
+	existing, err := r.prepareResource(ctx, u, orgNs, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Client.Patch(ctx, u, client.Apply, client.FieldOwner(usageProfileFieldManager), client.ForceOwnership, client.DryRunAll); err != nil {
+		return nil, fmt.Errorf("unable to dry run apply resource: %w", err)
+	}
+
+	diff, err := objectDiff(existing, u)
+	if err != nil {
+		return nil, err
+	}
+	change := &cloudagentv1.DryRunChange{
+		Namespace: orgNs.Name,
+		Resource:  name,
+		Kind:      u.GetKind(),
+		Action:    cloudagentv1.DryRunActionUpdate,
+		Diff:      diff,
+	}
+	if existing == nil {
+		change.Action = cloudagentv1.DryRunActionCreate
+	} else if diff == "" {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// deleteChange returns the change deleting the given object.
+func deleteChange(obj *unstructured.Unstructured) (cloudagentv1.DryRunChange, error) {
+	diff, err := objectDiff(obj, nil)
+	return cloudagentv1.DryRunChange{
+		Namespace: obj.GetNamespace(),
+		Resource:  obj.GetName(),
+		Kind:      obj.GetKind(),
+		Action:    cloudagentv1.DryRunActionDelete,
+		Diff:      diff,
+	}, err
+}
+
+// objectDiff returns the unified diff of the YAML representation of the given objects.
+// Metadata managed by the API server is ignored.
+// A nil object is diffed as an empty document.
+func objectDiff(from, to *unstructured.Unstructured) (string, error) {
+	a, err := diffableYAML(from)
+	if err != nil {
+		return "", err
+	}
+	b, err := diffableYAML(to)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "current",
+		ToFile:   "desired",
+		Context:  3,
+	})
+}
+
+func diffableYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	obj = obj.DeepCopy()
+	for _, f := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", f)
+	}
+	b, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal object: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_DryRun(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	profile := buildUsageProfile(t, scheme, "test")
+	for _, name := range []string{"org-limits", "old-limits"} {
+		profile.Spec.UpstreamSpec.Resources[name] = runtime.RawExtension{
+			Object: ensureGVK(t, scheme, &corev1.LimitRange{}),
+		}
+	}
+	subject, c := newApplyReconciler(t,
+		newNamespace("org1", map[string]string{orgLbl: "foo", "canary": "true"}, nil),
+		newNamespace("org2", map[string]string{orgLbl: "bar"}, nil),
+		profile,
+	)
+	fullRequest := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}}
+
+	_, err := subject.Reconcile(context.Background(), fullRequest)
+	require.NoError(t, err)
+
+	// Change the profile in dry run mode
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	profile.Annotations = map[string]string{dryRunAnnotation: "true"}
+	profile.Generation++
+	delete(profile.Spec.UpstreamSpec.Resources, "old-limits")
+	quotaResource := buildUsageProfile(t, scheme, "test").Spec.UpstreamSpec.Resources["org-usage"]
+	quotaResource.Object.(*corev1.ResourceQuota).Spec.Hard[corev1.ResourceCPU] = resource.MustParse("777")
+	profile.Spec.UpstreamSpec.Resources["org-usage"] = quotaResource
+	require.NoError(t, c.Update(context.Background(), profile))
+	require.NoError(t, c.Create(context.Background(), newNamespace("org3", map[string]string{orgLbl: "baz"}, nil)))
+
+	preview, err := subject.DryRun(context.Background(), *profile)
+	require.NoError(t, err)
+	quota := &corev1.ResourceQuota{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org1"}, quota))
+	assert.Equal(t, "666", quota.Spec.Hard.Cpu().String(), "should not update resources in a dry run")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "old-limits", Namespace: "org1"}, &corev1.LimitRange{}), "should not prune resources in a dry run")
+	require.Error(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org3"}, quota), "should not create resources in a dry run")
+
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org3"}, quota), "should reconcile as usual in dry run mode")
+	assert.Equal(t, "777", quota.Spec.Hard.Cpu().String())
+	require.Error(t, c.Get(context.Background(), types.NamespacedName{Name: "old-limits", Namespace: "org1"}, &corev1.LimitRange{}), "should prune as usual in dry run mode")
+
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	dryRun := profile.Status.DryRun
+	assert.Equal(t, &preview, dryRun, "should report the changes made by the reconcile")
+	require.NotNil(t, dryRun)
+	assert.Equal(t, profile.Generation, dryRun.ObservedGeneration)
+	assert.Equal(t, 2, dryRun.Creates)
+	assert.Equal(t, 2, dryRun.Updates)
+	assert.Equal(t, 2, dryRun.Deletes)
+	assert.Empty(t, dryRun.Failures)
+	actions := make([]string, 0, len(dryRun.Changes))
+	for _, c := range dryRun.Changes {
+		actions = append(actions, c.Namespace+"/"+c.Resource+":"+string(c.Action))
+	}
+	assert.Equal(t, []string{
+		"org1/old-limits:Delete",
+		"org1/org-usage:Update",
+		"org2/old-limits:Delete",
+		"org2/org-usage:Update",
+		"org3/org-limits:Create",
+		"org3/org-usage:Create",
+	}, actions, "should only report changed resources")
+	assert.Contains(t, dryRun.Changes[1].Diff, `-    cpu: "666"`)
+	assert.Contains(t, dryRun.Changes[1].Diff, `+    cpu: "777"`)
+
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	require.NotNil(t, profile.Status.DryRun)
+	assert.Empty(t, profile.Status.DryRun.Changes, "should not report changes already applied")
+
+	// Namespaces are diffed against the revision assigned by the rollout
+	subject.Rollout = RolloutStrategy{
+		CanaryNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+	}
+	profile.Generation++
+	quotaResource.Object.(*corev1.ResourceQuota).Spec.Hard[corev1.ResourceCPU] = resource.MustParse("888")
+	profile.Spec.UpstreamSpec.Resources["org-usage"] = quotaResource
+	require.NoError(t, c.Update(context.Background(), profile))
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	require.NotNil(t, profile.Status.DryRun)
+	actions = actions[:0]
+	for _, c := range profile.Status.DryRun.Changes {
+		actions = append(actions, c.Namespace+"/"+c.Resource+":"+string(c.Action))
+	}
+	assert.Equal(t, []string{"org1/org-usage:Update"}, actions, "should only report changes to the canary namespaces")
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: "org2"}, quota))
+	assert.Equal(t, "777", quota.Spec.Hard.Cpu().String())
+
+	// Disable dry run mode
+	delete(profile.Annotations, dryRunAnnotation)
+	require.NoError(t, c.Update(context.Background(), profile))
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	assert.Nil(t, profile.Status.DryRun)
+}
+
+func Test_objectDiff(t *testing.T) {
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "1", UID: "uid"},
+		Data:       map[string]string{"a": "1"},
+	})
+	require.NoError(t, err)
+	obj := &unstructured.Unstructured{Object: raw}
+	changed := obj.DeepCopy()
+	changed.SetResourceVersion("2")
+
+	diff, err := objectDiff(obj, changed)
+	require.NoError(t, err)
+	assert.Empty(t, diff, "should ignore server managed metadata")
+
+	diff, err = objectDiff(nil, obj)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+  a: \"1\"")
+	assert.NotContains(t, diff, "uid")
+}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/multierr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+)
+
+// rollbackAnnotation is the annotation on ZoneUsageProfiles restoring a revision from the ZoneUsageProfileRevisions of the ZoneUsageProfile.
+// The value is the generation of the revision to restore, or "true" to restore the newest revision of a different generation.
+// The revision is applied to all namespaces while the annotation is set.
+// Removing the annotation restarts the rollout of the current generation.
+const rollbackAnnotation = "cloud-agent.appuio.io/rollback"
+
+// RolloutStrategy configures the staged rollout of ZoneUsageProfile changes.
+// Changes are applied to the canary namespaces first, then to growing percentages of the other namespaces in waves.
+// Namespaces not yet reached by a rollout keep the last completely rolled out revision of the ZoneUsageProfile.
+// Changes are applied to all namespaces at once if neither canary namespaces nor waves are configured.
+// Completely rolled out revisions are kept as ZoneUsageProfileRevisions owned by the ZoneUsageProfile.
+// Without them, for example after the ZoneUsageProfile was re-created, rollbacks are unavailable
+// and the next change is applied to all namespaces at once without halting.
+type RolloutStrategy struct {
+	// CanaryNamespaceSelector selects the namespaces a change is applied to first.
+	CanaryNamespaceSelector *metav1.LabelSelector
+	// WavePercentages are the cumulative percentages of the non-canary namespaces a change is applied to in each wave.
+	// A last wave applying the change to all namespaces is added if the last percentage is below 100.
+	WavePercentages []int
+	// WavePause is the time a stage must be applied before the rollout proceeds to the next stage.
+	WavePause metav1.Duration
+	// MaxFailures is the number of resources allowed to fail to apply to the namespaces reached by a rollout.
+	// The rollout is halted if more resources fail to apply, and resumed once at most MaxFailures resources fail to apply.
+	MaxFailures int
+}
+
+// Enabled returns true if canary namespaces or waves are configured.
+func (s RolloutStrategy) Enabled() bool {
+	return s.CanaryNamespaceSelector != nil || len(s.WavePercentages) > 0
+}
+
+// Validate validates the rollout strategy.
+func (s RolloutStrategy) Validate() error {
+	var errs []error
+	if s.CanaryNamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(s.CanaryNamespaceSelector); err != nil {
+			errs = append(errs, fmt.Errorf("invalid CanaryNamespaceSelector: %w", err))
+		}
+	}
+	for i, p := range s.WavePercentages {
+		if p <= 0 || p > 100 || (i > 0 && p <= s.WavePercentages[i-1]) {
+			errs = append(errs, fmt.Errorf("WavePercentages must be increasing and between 1 and 100, got %v", s.WavePercentages))
+			break
+		}
+	}
+	if s.WavePause.Duration < 0 {
+		errs = append(errs, fmt.Errorf("WavePause must not be negative, got %s", s.WavePause.Duration))
+	}
+	if s.MaxFailures < 0 {
+		errs = append(errs, fmt.Errorf("MaxFailures must not be negative, got %d", s.MaxFailures))
+	}
+	return multierr.Combine(errs...)
+}
+
+// stagePercentages returns the percentage of non-canary namespaces reached by each stage of a rollout.
+// The canary stage reaches none of the non-canary namespaces, the last stage reaches all namespaces.
+func (s RolloutStrategy) stagePercentages() []int {
+	var stages []int
+	if s.CanaryNamespaceSelector != nil {
+		stages = append(stages, 0)
+	}
+	stages = append(stages, s.WavePercentages...)
+	if len(stages) == 0 || stages[len(stages)-1] < 100 {
+		stages = append(stages, 100)
+	}
+	return stages
+}
+
+// rolloutPlan are the revisions of a ZoneUsageProfile applied to the namespaces during a reconcile.
+type rolloutPlan struct {
+	rollout cloudagentv1.ZoneUsageProfileRollout
+
+	// target is applied to the namespaces reached by the rollout.
+	target cloudagentv1.ZoneUsageProfile
+	// stable is applied to the namespaces not yet reached by the rollout.
+	// Nil if all namespaces are reached.
+	stable *cloudagentv1.ZoneUsageProfile
+
+	// percentage is the percentage of non-canary namespaces reached by the rollout.
+	percentage int
+	canary     labels.Selector
+
+	// history are the completely rolled out revisions of the ZoneUsageProfile, newest first.
+	history []cloudagentv1.ZoneUsageProfileRevision
+}
+
+// planRollout returns the revisions to apply to the namespaces for the current stage of the rollout of the ZoneUsageProfile.
+// A new rollout is started if the generation of the ZoneUsageProfile changed.
+// Rollouts without a previous revision in the history, or without a configured RolloutStrategy, apply the ZoneUsageProfile to all namespaces at once.
+// A revision selected by the rollbackAnnotation is applied to all namespaces instead.
+// The history is read from the ZoneUsageProfileRevisions of the ZoneUsageProfile.
+func (r *ZoneUsageProfileApplyReconciler) planRollout(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, now time.Time) (rolloutPlan, error) {
+	history, err := r.revisionHistory(ctx, profile)
+	if err != nil {
+		return rolloutPlan{}, err
+	}
+
+	canary := labels.Nothing()
+	if r.Rollout.CanaryNamespaceSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(r.Rollout.CanaryNamespaceSelector)
+		if err != nil {
+			return rolloutPlan{}, fmt.Errorf("invalid canary namespace selector: %w", err)
+		}
+		canary = s
+	}
+	stages := r.Rollout.stagePercentages()
+	plan := rolloutPlan{target: profile, percentage: 100, canary: canary, history: history}
+	current := profile.Status.Rollout
+
+	rollback, err := rollbackRevision(profile, history)
+	if err != nil {
+		return plan, err
+	}
+	if rollback != nil {
+		plan.target.Spec.UpstreamSpec = rollback.Spec.UpstreamSpec
+		plan.rollout = cloudagentv1.ZoneUsageProfileRollout{
+			Generation:     profile.Generation,
+			Phase:          cloudagentv1.RolloutPhaseRolledBack,
+			Stages:         len(stages),
+			StageStartedAt: metav1.NewTime(now),
+			Message:        fmt.Sprintf("Rolled back to generation %d", rollback.Spec.Generation),
+		}
+		if current != nil && current.Generation == plan.rollout.Generation && current.Message == plan.rollout.Message {
+			plan.rollout.StageStartedAt = current.StageStartedAt
+		}
+		return plan, nil
+	}
+
+	var stable *cloudagentv1.ZoneUsageProfileRevision
+	if len(history) > 0 {
+		stable = &history[0]
+	}
+
+	if current != nil && current.Generation == profile.Generation && current.Phase != cloudagentv1.RolloutPhaseRolledBack {
+		plan.rollout = *current
+	} else {
+		plan.rollout = cloudagentv1.ZoneUsageProfileRollout{
+			Generation:     profile.Generation,
+			Phase:          cloudagentv1.RolloutPhaseProgressing,
+			Stages:         len(stages),
+			StageStartedAt: metav1.NewTime(now),
+		}
+		if !r.Rollout.Enabled() || stable == nil || stable.Spec.Generation == profile.Generation {
+			// There is no previous revision to keep, or no stages to roll out in.
+			plan.rollout.Stage = len(stages) - 1
+		}
+		plan.rollout.Message = fmt.Sprintf("Rolling out to stage %d of %d", plan.rollout.Stage+1, plan.rollout.Stages)
+	}
+	if plan.rollout.Phase == cloudagentv1.RolloutPhaseCompleted || stable == nil {
+		return plan, nil
+	}
+
+	// The strategy might have been reconfigured since the rollout started.
+	plan.rollout.Stages = len(stages)
+	plan.rollout.Stage = min(plan.rollout.Stage, len(stages)-1)
+	plan.percentage = stages[plan.rollout.Stage]
+	if plan.percentage < 100 {
+		stableProfile := profile
+		stableProfile.Spec.UpstreamSpec = stable.Spec.UpstreamSpec
+		plan.stable = &stableProfile
+	}
+	return plan, nil
+}
+
+// profileForNamespace returns the revision of the ZoneUsageProfile to apply to the namespace.
+// It returns true if the namespace is reached by the rollout.
+func (p rolloutPlan) profileForNamespace(ns corev1.Namespace) (cloudagentv1.ZoneUsageProfile, bool) {
+	if p.stable == nil || p.canary.Matches(labels.Set(ns.Labels)) || namespaceBucket(p.target.Name, ns.Name) < p.percentage {
+		return p.target, true
+	}
+	return *p.stable, false
+}
+
+// appliedResources returns the sorted resources of all revisions applied by the plan.
+func (p rolloutPlan) appliedResources() ([]cloudagentv1.AppliedResource, error) {
+	applied, err := appliedResources(p.target)
+	if err != nil || p.stable == nil {
+		return applied, err
+	}
+	stable, err := appliedResources(*p.stable)
+	if err != nil {
+		return nil, err
+	}
+	return mergeAppliedResources(applied, stable), nil
+}
+
+// namespaceBucket returns a stable bucket between 0 and 99 for the namespace.
+// Namespaces with a bucket below the percentage of a stage are reached by the stage.
+func namespaceBucket(profile, namespace string) int {
+	h := fnv.New32a()
+	h.Write([]byte(profile + "/" + namespace))
+	return int(h.Sum32() % 100)
+}
+
+// progressRollout records the rollout of the plan in the status of the ZoneUsageProfile after the current stage was applied.
+// The rollout is halted if more than MaxFailures resources failed to apply to the namespaces reached by the rollout.
+// Rollouts without a stable revision to keep in the other namespaces are not halted.
+// Halted rollouts are resumed once at most MaxFailures resources fail to apply, for example after MaxFailures was raised.
+// Otherwise the rollout proceeds to the next stage once the WavePause passed, or completes after the last stage.
+// Completed rollouts are recorded as ZoneUsageProfileRevisions, the rollout is not completed if recording the revision fails.
+func (r *ZoneUsageProfileApplyReconciler) progressRollout(ctx context.Context, profile *cloudagentv1.ZoneUsageProfile, plan rolloutPlan, failures int, now time.Time) (ctrl.Result, error) {
+	rollout := plan.rollout
+	var res ctrl.Result
+	var err error
+
+	switch {
+	case rollout.Phase != cloudagentv1.RolloutPhaseProgressing && rollout.Phase != cloudagentv1.RolloutPhaseHalted:
+	case r.Rollout.Enabled() && plan.stable != nil && failures > r.Rollout.MaxFailures:
+		message := fmt.Sprintf("Halted at stage %d of %d: %d resources failed to apply, at most %d allowed", rollout.Stage+1, rollout.Stages, failures, r.Rollout.MaxFailures)
+		if rollout.Phase != cloudagentv1.RolloutPhaseHalted {
+			r.Recorder.Event(profile, "Warning", "RolloutHalted", message)
+		}
+		rollout.Phase = cloudagentv1.RolloutPhaseHalted
+		rollout.Message = message
+	case rollout.Phase == cloudagentv1.RolloutPhaseHalted:
+		rollout.Phase = cloudagentv1.RolloutPhaseProgressing
+		rollout.StageStartedAt = metav1.NewTime(now)
+		rollout.Message = fmt.Sprintf("Resumed rolling out to stage %d of %d", rollout.Stage+1, rollout.Stages)
+		r.Recorder.Event(profile, "Normal", "RolloutResumed", rollout.Message)
+		res.Requeue = true
+	case rollout.Stage >= rollout.Stages-1:
+		if err = r.recordRevision(ctx, *profile, plan.history, now); err != nil {
+			break
+		}
+		rollout.Phase = cloudagentv1.RolloutPhaseCompleted
+		rollout.Message = "Rolled out to all namespaces"
+	default:
+		if wait := rollout.StageStartedAt.Add(r.Rollout.WavePause.Duration).Sub(now); wait > 0 {
+			res.RequeueAfter = wait
+			break
+		}
+		rollout.Stage++
+		rollout.StageStartedAt = metav1.NewTime(now)
+		rollout.Message = fmt.Sprintf("Rolling out to stage %d of %d", rollout.Stage+1, rollout.Stages)
+		res.Requeue = true
+	}
+
+	profile.Status.Rollout = &rollout
+	return res, err
+}
+
+// revisionHistory returns the ZoneUsageProfileRevisions of the ZoneUsageProfile, newest first.
+func (r *ZoneUsageProfileApplyReconciler) revisionHistory(ctx context.Context, profile cloudagentv1.ZoneUsageProfile) ([]cloudagentv1.ZoneUsageProfileRevision, error) {
+	var revisions cloudagentv1.ZoneUsageProfileRevisionList
+	if err := r.Client.List(ctx, &revisions, client.MatchingLabels{resourceOwnerLabel: profile.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list ZoneUsageProfileRevisions: %w", err)
+	}
+	history := slices.DeleteFunc(revisions.Items, func(rev cloudagentv1.ZoneUsageProfileRevision) bool {
+		return rev.Spec.Profile != profile.Name || rev.DeletionTimestamp != nil
+	})
+	slices.SortFunc(history, func(a, b cloudagentv1.ZoneUsageProfileRevision) int {
+		return cmp.Or(b.Spec.CompletedAt.Compare(a.Spec.CompletedAt.Time), cmp.Compare(b.Spec.Generation, a.Spec.Generation))
+	})
+	return history, nil
+}
+
+// recordRevision records the current generation of the ZoneUsageProfile as a ZoneUsageProfileRevision owned by the ZoneUsageProfile.
+// An existing revision of the same generation is replaced.
+// Revisions beyond the newest MaxRevisionHistory revisions are deleted.
+func (r *ZoneUsageProfileApplyReconciler) recordRevision(ctx context.Context, profile cloudagentv1.ZoneUsageProfile, history []cloudagentv1.ZoneUsageProfileRevision, now time.Time) error {
+	rev := &cloudagentv1.ZoneUsageProfileRevision{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%d", profile.Name, profile.Generation)},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, rev, func() error {
+		rev.Labels = map[string]string{resourceOwnerLabel: profile.Name}
+		rev.Spec = cloudagentv1.ZoneUsageProfileRevisionSpec{
+			Profile:      profile.Name,
+			Generation:   profile.Generation,
+			UpstreamSpec: *profile.Spec.UpstreamSpec.DeepCopy(),
+			CompletedAt:  metav1.NewTime(now),
+		}
+		return controllerutil.SetControllerReference(&profile, rev, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to record ZoneUsageProfileRevision: %w", err)
+	}
+
+	history = slices.DeleteFunc(slices.Clone(history), func(r cloudagentv1.ZoneUsageProfileRevision) bool {
+		return r.Name == rev.Name
+	})
+	history = slices.Insert(history, 0, *rev)
+	var errs []error
+	for _, old := range history[min(len(history), cloudagentv1.MaxRevisionHistory):] {
+		if err := r.Client.Delete(ctx, &old); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("unable to delete ZoneUsageProfileRevision %q: %w", old.Name, err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// rollbackRevision returns the revision selected by the rollbackAnnotation of the ZoneUsageProfile from the history.
+// It returns nil if the annotation is not set.
+func rollbackRevision(profile cloudagentv1.ZoneUsageProfile, history []cloudagentv1.ZoneUsageProfileRevision) (*cloudagentv1.ZoneUsageProfileRevision, error) {
+	value, ok := profile.Annotations[rollbackAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	if value == "true" {
+		for i, rev := range history {
+			if rev.Spec.Generation != profile.Generation {
+				return &history[i], nil
+			}
+		}
+		return nil, errors.New("unable to roll back: no previous revision in history")
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to roll back: invalid value %q of annotation %q, expected \"true\" or a generation", value, rollbackAnnotation)
+	}
+	for i, rev := range history {
+		if rev.Spec.Generation == generation {
+			return &history[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unable to roll back: generation %d not in history", generation)
+}
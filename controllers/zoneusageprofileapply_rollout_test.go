@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+)
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_Rollout(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	namespaces := []string{"canary"}
+	objs := []client.Object{
+		newNamespace("canary", map[string]string{orgLbl: "canary", "canary": "true"}, nil),
+		buildUsageProfile(t, scheme, "test"),
+	}
+	for i := range 10 {
+		name := fmt.Sprintf("org%d", i)
+		namespaces = append(namespaces, name)
+		objs = append(objs, newNamespace(name, map[string]string{orgLbl: name}, nil))
+	}
+	subject, c := newApplyReconciler(t, objs...)
+	subject.Rollout = RolloutStrategy{
+		CanaryNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+		WavePercentages:         []int{50},
+		WavePause:               metav1.Duration{Duration: time.Hour},
+	}
+	fullRequest := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}}
+
+	cpu := func(ns string) string {
+		t.Helper()
+		var quota corev1.ResourceQuota
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "org-usage", Namespace: ns}, &quota))
+		return quota.Spec.Hard.Cpu().String()
+	}
+	assertCPU := func(expected func(ns string) string) {
+		t.Helper()
+		for _, ns := range namespaces {
+			assert.Equal(t, expected(ns), cpu(ns), "namespace %s", ns)
+		}
+	}
+	getProfile := func() *cloudagentv1.ZoneUsageProfile {
+		t.Helper()
+		profile := &cloudagentv1.ZoneUsageProfile{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+		return profile
+	}
+	updateProfile := func(generation int64, cpu string, mutate func(*cloudagentv1.ZoneUsageProfile)) {
+		t.Helper()
+		profile := getProfile()
+		profile.Generation = generation
+		quotaResource := buildUsageProfile(t, scheme, "test").Spec.UpstreamSpec.Resources["org-usage"]
+		quotaResource.Object.(*corev1.ResourceQuota).Spec.Hard[corev1.ResourceCPU] = resource.MustParse(cpu)
+		profile.Spec.UpstreamSpec.Resources["org-usage"] = quotaResource
+		if mutate != nil {
+			mutate(profile)
+		}
+		require.NoError(t, c.Update(context.Background(), profile))
+	}
+	reconcileFull := func() reconcile.Result {
+		t.Helper()
+		res, err := subject.Reconcile(context.Background(), fullRequest)
+		require.NoError(t, err)
+		return res
+	}
+
+	// Profiles without history are applied to all namespaces at once
+	updateProfile(1, "666", nil)
+	reconcileFull()
+	assertCPU(func(string) string { return "666" })
+	profile := getProfile()
+	require.NotNil(t, profile.Status.Rollout)
+	assert.Equal(t, cloudagentv1.RolloutPhaseCompleted, profile.Status.Rollout.Phase)
+	history, err := subject.revisionHistory(context.Background(), *profile)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, int64(1), history[0].Spec.Generation)
+	assert.Equal(t, "test", history[0].Labels[resourceOwnerLabel])
+	require.NotNil(t, metav1.GetControllerOf(&history[0]))
+	assert.Equal(t, "test", metav1.GetControllerOf(&history[0]).Name)
+
+	// Changes are applied to the canary namespaces first and wait for the pause
+	updateProfile(2, "777", nil)
+	res := reconcileFull()
+	assert.Greater(t, res.RequeueAfter, time.Duration(0))
+	assertCPU(func(ns string) string {
+		if ns == "canary" {
+			return "777"
+		}
+		return "666"
+	})
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseProgressing, profile.Status.Rollout.Phase)
+	assert.Equal(t, 0, profile.Status.Rollout.Stage)
+	assert.Equal(t, 3, profile.Status.Rollout.Stages)
+
+	// Namespace requests respect the rollout
+	_, err = subject.Reconcile(context.Background(), namespaceRequest("test", "org0"))
+	require.NoError(t, err)
+	assert.Equal(t, "666", cpu("org0"))
+
+	// Waves reach a percentage of the other namespaces
+	subject.Rollout.WavePause = metav1.Duration{}
+	res = reconcileFull()
+	assert.True(t, res.Requeue)
+	assert.Equal(t, 1, getProfile().Status.Rollout.Stage)
+	reconcileFull()
+	reached := 0
+	assertCPU(func(ns string) string {
+		if ns == "canary" || namespaceBucket("test", ns) < 50 {
+			reached++
+			return "777"
+		}
+		return "666"
+	})
+	assert.Greater(t, reached, 1, "should reach namespaces besides the canary in the first wave")
+	assert.Less(t, reached, len(namespaces), "should not reach all namespaces in the first wave")
+	reconcileFull()
+	assertCPU(func(string) string { return "777" })
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseCompleted, profile.Status.Rollout.Phase)
+	history, err = subject.revisionHistory(context.Background(), *profile)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, int64(2), history[0].Spec.Generation)
+
+	// Failures in the canary namespaces halt the rollout
+	updateProfile(3, "888", func(p *cloudagentv1.ZoneUsageProfile) {
+		p.Spec.UpstreamSpec.Resources["broken"] = runtime.RawExtension{
+			Object: ensureGVK(t, scheme, &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{resourceNamespaceSelectorAnnotation: "!!invalid"},
+			}}),
+		}
+	})
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.Error(t, err)
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.Error(t, err)
+	assertCPU(func(ns string) string {
+		if ns == "canary" {
+			return "888"
+		}
+		return "777"
+	})
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseHalted, profile.Status.Rollout.Phase)
+	assert.Equal(t, 0, profile.Status.Rollout.Stage)
+
+	// Halted rollouts resume once the failures are within MaxFailures
+	subject.Rollout.MaxFailures = 1
+	res, err = subject.Reconcile(context.Background(), fullRequest)
+	require.Error(t, err)
+	assert.True(t, res.Requeue)
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseProgressing, profile.Status.Rollout.Phase)
+	assert.Equal(t, "Resumed rolling out to stage 1 of 3", profile.Status.Rollout.Message)
+	subject.Rollout.MaxFailures = 0
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.Error(t, err)
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseHalted, profile.Status.Rollout.Phase)
+
+	// The rollback annotation restores a revision from the history in all namespaces
+	profile.Annotations = map[string]string{rollbackAnnotation: "true"}
+	require.NoError(t, c.Update(context.Background(), profile))
+	reconcileFull()
+	assertCPU(func(string) string { return "777" })
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseRolledBack, profile.Status.Rollout.Phase)
+	assert.Equal(t, "Rolled back to generation 2", profile.Status.Rollout.Message)
+
+	profile.Annotations[rollbackAnnotation] = "1"
+	require.NoError(t, c.Update(context.Background(), profile))
+	reconcileFull()
+	assertCPU(func(string) string { return "666" })
+
+	profile = getProfile()
+	profile.Annotations[rollbackAnnotation] = "5"
+	require.NoError(t, c.Update(context.Background(), profile))
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.ErrorContains(t, err, "generation 5 not in history")
+
+	// Removing the annotation restarts the rollout
+	profile = getProfile()
+	delete(profile.Annotations, rollbackAnnotation)
+	require.NoError(t, c.Update(context.Background(), profile))
+	_, err = subject.Reconcile(context.Background(), fullRequest)
+	require.Error(t, err)
+	profile = getProfile()
+	assert.Equal(t, cloudagentv1.RolloutPhaseHalted, profile.Status.Rollout.Phase)
+	assert.Equal(t, "888", cpu("canary"))
+	assert.Equal(t, "777", cpu("org0"), "should apply the last completed revision to namespaces not reached")
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_RolloutWithoutHistory(t *testing.T) {
+	const orgLbl = "test.com/organization"
+
+	_, scheme, _ := prepareClient(t)
+	profile := buildUsageProfile(t, scheme, "test")
+	profile.Generation = 1
+	profile.Spec.UpstreamSpec.Resources["broken"] = runtime.RawExtension{
+		Object: ensureGVK(t, scheme, &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{resourceNamespaceSelectorAnnotation: "!!invalid"},
+		}}),
+	}
+	subject, c := newApplyReconciler(t, newNamespace("canary", map[string]string{orgLbl: "canary", "canary": "true"}, nil), profile)
+	subject.Rollout = RolloutStrategy{
+		CanaryNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+	}
+
+	_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+	require.Error(t, err)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, profile))
+	require.NotNil(t, profile.Status.Rollout)
+	assert.Equal(t, cloudagentv1.RolloutPhaseCompleted, profile.Status.Rollout.Phase, "should not halt without a stable revision to keep")
+	history, err := subject.revisionHistory(context.Background(), *profile)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
+
+func Test_ZoneUsageProfileApplyReconciler_Reconcile_RevisionHistory(t *testing.T) {
+	_, scheme, _ := prepareClient(t)
+	subject, c := newApplyReconciler(t, buildUsageProfile(t, scheme, "test"), buildUsageProfile(t, scheme, "other"))
+
+	for _, name := range []string{"other", "test"} {
+		for generation := int64(1); generation <= cloudagentv1.MaxRevisionHistory+2; generation++ {
+			var profile cloudagentv1.ZoneUsageProfile
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: name}, &profile))
+			profile.Generation = generation
+			require.NoError(t, c.Update(context.Background(), &profile))
+			_, err := subject.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+			require.NoError(t, err)
+		}
+	}
+
+	var profile cloudagentv1.ZoneUsageProfile
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "test"}, &profile))
+	history, err := subject.revisionHistory(context.Background(), profile)
+	require.NoError(t, err)
+	generations := make([]int64, 0, len(history))
+	for _, rev := range history {
+		assert.Equal(t, "test", rev.Spec.Profile)
+		generations = append(generations, rev.Spec.Generation)
+	}
+	assert.Equal(t, []int64{7, 6, 5, 4, 3}, generations, "should keep the newest MaxRevisionHistory revisions, newest first")
+
+	var revisions cloudagentv1.ZoneUsageProfileRevisionList
+	require.NoError(t, c.List(context.Background(), &revisions))
+	assert.Len(t, revisions.Items, 2*cloudagentv1.MaxRevisionHistory)
+}
+
+func Test_RolloutStrategy_stagePercentages(t *testing.T) {
+	canary := &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}}
+	testCases := []struct {
+		desc     string
+		strategy RolloutStrategy
+		expected []int
+	}{
+		{"disabled", RolloutStrategy{}, []int{100}},
+		{"canary", RolloutStrategy{CanaryNamespaceSelector: canary}, []int{0, 100}},
+		{"waves", RolloutStrategy{WavePercentages: []int{10, 50}}, []int{10, 50, 100}},
+		{"canary and waves", RolloutStrategy{CanaryNamespaceSelector: canary, WavePercentages: []int{25, 100}}, []int{0, 25, 100}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expected, tC.strategy.stagePercentages())
+			assert.NoError(t, tC.strategy.Validate())
+		})
+	}
+}
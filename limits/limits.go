@@ -1,14 +1,70 @@
 package limits
 
 import (
+	"fmt"
+
+	"go.uber.org/multierr"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Enforcement defines how a limit is enforced.
+type Enforcement string
+
+const (
+	// EnforcementWarn only emits warnings if the limit is exceeded. This is the default.
+	EnforcementWarn Enforcement = "Warn"
+	// EnforcementDeny denies creating workloads that push the ratio below the hard limit.
+	EnforcementDeny Enforcement = "Deny"
+	// EnforcementDenyAfterGracePeriod denies creating workloads that push the ratio below the hard limit
+	// if the ratio has already been below the hard limit for longer than the grace period.
+	EnforcementDenyAfterGracePeriod Enforcement = "DenyAfterGracePeriod"
+)
+
 type Limit struct {
 	NodeSelector metav1.LabelSelector
 	Limit        *resource.Quantity
+
+	// Enforcement defines how the limit is enforced.
+	// Defaults to EnforcementWarn.
+	Enforcement Enforcement
+	// HardLimit is the ratio below which workload creations are denied if the limit is enforced.
+	// Defaults to Limit.
+	HardLimit *resource.Quantity
+	// GracePeriod is the time a namespace is allowed to be below the hard limit before workload creations are denied.
+	// Only used with EnforcementDenyAfterGracePeriod.
+	GracePeriod metav1.Duration
+}
+
+// Enforced returns true if workload creations can be denied by the limit.
+func (l Limit) Enforced() bool {
+	return l.Enforcement == EnforcementDeny || l.Enforcement == EnforcementDenyAfterGracePeriod
+}
+
+// GetHardLimit returns the hard limit or the limit if no hard limit is set.
+func (l Limit) GetHardLimit() *resource.Quantity {
+	if l.HardLimit != nil {
+		return l.HardLimit
+	}
+	return l.Limit
+}
+
+// Validate validates the enforcement configuration of the limit.
+func (l Limit) Validate() error {
+	switch l.Enforcement {
+	case "", EnforcementWarn, EnforcementDeny:
+	case EnforcementDenyAfterGracePeriod:
+		if l.GracePeriod.Duration <= 0 {
+			return fmt.Errorf("GracePeriod must be positive for enforcement %q", l.Enforcement)
+		}
+	default:
+		return fmt.Errorf("unknown enforcement %q", l.Enforcement)
+	}
+	if l.Enforced() && l.GetHardLimit() == nil {
+		return fmt.Errorf("Limit or HardLimit must be set for enforcement %q", l.Enforcement)
+	}
+	return nil
 }
 
 type Limits []Limit
@@ -17,16 +73,53 @@ type Limits []Limit
 // If no limit matches, an empty string is returned.
 // Limits with invalid node selectors are ignored.
 func (l Limits) GetLimitForNodeSelector(nodeSelector map[string]string) *resource.Quantity {
-	for _, limit := range l {
+	limit := l.GetForNodeSelector(nodeSelector)
+	if limit == nil {
+		return nil
+	}
+	return limit.Limit
+}
+
+// GetForNodeSelector returns the first Limit that matches the given node selector.
+// If no limit matches, nil is returned.
+// Limits with invalid node selectors are ignored.
+func (l Limits) GetForNodeSelector(nodeSelector map[string]string) *Limit {
+	for i, limit := range l {
 		limitSel, err := metav1.LabelSelectorAsSelector(&limit.NodeSelector)
 		if err != nil {
 			continue
 		}
 
 		if limitSel.Matches(labels.Set(nodeSelector)) {
-			return limit.Limit
+			return &l[i]
 		}
 	}
 
 	return nil
 }
+
+// WithOverride returns a copy of the limits with the limit of every entry replaced by the given limit.
+// Hard limits above the given limit are lowered to the given limit.
+func (l Limits) WithOverride(limit resource.Quantity) Limits {
+	overridden := make(Limits, len(l))
+	for i, lim := range l {
+		override := limit.DeepCopy()
+		lim.Limit = &override
+		if lim.HardLimit != nil && lim.HardLimit.Cmp(limit) > 0 {
+			lim.HardLimit = &override
+		}
+		overridden[i] = lim
+	}
+	return overridden
+}
+
+// Validate validates all limits.
+func (l Limits) Validate() error {
+	errs := make([]error, 0, len(l))
+	for i, limit := range l {
+		if err := limit.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("limit %d: %w", i, err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
@@ -2,6 +2,7 @@ package limits_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -80,3 +81,71 @@ func requireParseQuantity(t *testing.T, s string) *resource.Quantity {
 	require.NoError(t, err)
 	return &q
 }
+
+func TestGetForNodeSelector(t *testing.T) {
+	subject := limits.Limits{
+		{
+			NodeSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"class": "highmem"},
+			},
+			Limit:       requireParseQuantity(t, "14Gi"),
+			Enforcement: limits.EnforcementDeny,
+			HardLimit:   requireParseQuantity(t, "7Gi"),
+		},
+		{
+			Limit: requireParseQuantity(t, "4Gi"),
+		},
+	}
+
+	highmem := subject.GetForNodeSelector(map[string]string{"class": "highmem"})
+	require.NotNil(t, highmem)
+	assert.True(t, highmem.Enforced())
+	assert.Equal(t, "7Gi", highmem.GetHardLimit().String())
+
+	other := subject.GetForNodeSelector(map[string]string{"class": "other"})
+	require.NotNil(t, other)
+	assert.False(t, other.Enforced())
+	assert.Equal(t, "4Gi", other.GetHardLimit().String(), "hard limit should default to limit")
+}
+
+func TestLimits_Validate(t *testing.T) {
+	assert.NoError(t, limits.Limits{
+		{Limit: requireParseQuantity(t, "4Gi")},
+		{Limit: requireParseQuantity(t, "4Gi"), Enforcement: limits.EnforcementWarn},
+		{Limit: requireParseQuantity(t, "4Gi"), Enforcement: limits.EnforcementDeny},
+		{Limit: requireParseQuantity(t, "4Gi"), Enforcement: limits.EnforcementDenyAfterGracePeriod, GracePeriod: metav1.Duration{Duration: time.Hour}},
+	}.Validate())
+
+	assert.Error(t, limits.Limits{{Limit: requireParseQuantity(t, "4Gi"), Enforcement: "Banana"}}.Validate())
+	assert.Error(t, limits.Limits{{Limit: requireParseQuantity(t, "4Gi"), Enforcement: limits.EnforcementDenyAfterGracePeriod}}.Validate())
+	assert.Error(t, limits.Limits{{Enforcement: limits.EnforcementDeny}}.Validate())
+}
+
+func TestLimits_WithOverride(t *testing.T) {
+	subject := limits.Limits{
+		{
+			NodeSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"class": "highmem"},
+			},
+			Limit:       requireParseQuantity(t, "14Gi"),
+			Enforcement: limits.EnforcementDeny,
+			HardLimit:   requireParseQuantity(t, "7Gi"),
+		},
+		{
+			Limit:     requireParseQuantity(t, "4Gi"),
+			HardLimit: requireParseQuantity(t, "1Gi"),
+		},
+	}
+
+	overridden := subject.WithOverride(resource.MustParse("2Gi"))
+	require.Len(t, overridden, 2)
+	assert.Equal(t, "2Gi", overridden[0].Limit.String())
+	assert.Equal(t, "2Gi", overridden[0].HardLimit.String(), "hard limit should be lowered to the override")
+	assert.Equal(t, limits.EnforcementDeny, overridden[0].Enforcement)
+	assert.Equal(t, subject[0].NodeSelector, overridden[0].NodeSelector)
+	assert.Equal(t, "2Gi", overridden[1].Limit.String())
+	assert.Equal(t, "1Gi", overridden[1].HardLimit.String())
+
+	assert.Equal(t, "14Gi", subject[0].Limit.String(), "original limits should not be modified")
+	assert.Equal(t, "7Gi", subject[0].HardLimit.String(), "original limits should not be modified")
+}
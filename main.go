@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"maps"
 	"os"
 	"slices"
 	"time"
 
+	orgv1 "github.com/appuio/control-api/apis/organization/v1"
 	controlv1 "github.com/appuio/control-api/apis/v1"
+	oappsv1 "github.com/openshift/api/apps/v1"
 	projectv1 "github.com/openshift/api/project/v1"
 	userv1 "github.com/openshift/api/user/v1"
 	"go.uber.org/multierr"
+	"golang.org/x/time/rate"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -30,6 +34,7 @@ import (
 	"github.com/appuio/appuio-cloud-agent/controllers"
 	"github.com/appuio/appuio-cloud-agent/controllers/clustersource"
 	"github.com/appuio/appuio-cloud-agent/controllers/transformers"
+	"github.com/appuio/appuio-cloud-agent/nsquota"
 	"github.com/appuio/appuio-cloud-agent/ratio"
 	"github.com/appuio/appuio-cloud-agent/skipper"
 	"github.com/appuio/appuio-cloud-agent/webhooks"
@@ -59,11 +64,21 @@ func init() {
 	utilruntime.Must(projectv1.AddToScheme(scheme))
 	utilruntime.Must(agentv1.AddToScheme(scheme))
 	utilruntime.Must(controlv1.AddToScheme(scheme))
+	utilruntime.Must(orgv1.AddToScheme(scheme))
 	utilruntime.Must(configv1.AddToScheme(scheme))
+	utilruntime.Must(oappsv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == usageProfileDiffCommand {
+		if err := runUsageProfileDiff(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	metricsAddr := flag.String("metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	enableLeaderElection := flag.Bool("leader-elect", false,
 		"Enable leader election for controller manager. "+
@@ -84,7 +99,7 @@ func main() {
 	flag.StringVar(&upstreamZoneIdentifier, "upstream-zone-identifier", "", "Identifies the agent in the control API. Currently used for Team/OrganizationMembers finalizer and the K8s version reporting.")
 
 	var selectedUsageProfile string
-	flag.StringVar(&selectedUsageProfile, "usage-profile", "", "UsageProfile to use. Applies all profiles if empty. Dynamic selection is not supported yet.")
+	flag.StringVar(&selectedUsageProfile, "usage-profile", "", "UsageProfile to use for organizations not selecting a profile with the UsageProfileLabel. Applies all profiles to those organizations if empty.")
 
 	var cloudscaleLoadbalancerValidationEnabled bool
 	flag.BoolVar(&cloudscaleLoadbalancerValidationEnabled, "cloudscale-loadbalancer-validation-enabled", false, "Enable Cloudscale Loadbalancer validation. Validates that the k8s.cloudscale.ch/loadbalancer-uuid annotation cannot be changed by unprivileged users.")
@@ -108,10 +123,15 @@ func main() {
 	flag.IntVar(&qps, "qps", 20, "QPS to use for the controller-runtime client")
 	flag.IntVar(&burst, "burst", 100, "Burst to use for the controller-runtime client")
 
-	var disableUserAttributeSync, disableGroupSync, disableUsageProfiles bool
+	var disableUserAttributeSync, disableGroupSync, disableUsageProfiles, disableUsageProfilePruning bool
 	flag.BoolVar(&disableUserAttributeSync, "disable-user-attribute-sync", false, "Disable the UserAttributeSync controller")
 	flag.BoolVar(&disableGroupSync, "disable-group-sync", false, "Disable the GroupSync controller")
 	flag.BoolVar(&disableUsageProfiles, "disable-usage-profiles", false, "Disable the UsageProfile controllers")
+	flag.BoolVar(&disableUsageProfilePruning, "disable-usage-profile-pruning", false, "Disable the deletion of resources no longer defined in a ZoneUsageProfile or no longer selected for a namespace")
+	var usageProfileApplyConcurrency int
+	flag.IntVar(&usageProfileApplyConcurrency, "usage-profile-apply-concurrency", 10, "Maximum number of namespaces a ZoneUsageProfile is applied to in parallel")
+	var usageProfileApplyRate float64
+	flag.Float64Var(&usageProfileApplyRate, "usage-profile-apply-rate", 50, "Maximum number of namespaces per second a ZoneUsageProfile is applied to. Not rate limited if 0")
 
 	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
@@ -184,7 +204,15 @@ func main() {
 		os.Exit(1)
 	}
 
-	registerRatioController(mgr, conf, conf.OrganizationLabel)
+	psk := &skipper.PrivilegedUserSkipper{
+		Client: mgr.GetClient(),
+
+		PrivilegedUsers:        append(conf.PrivilegedUsers, whoami(mgr).Username),
+		PrivilegedGroups:       conf.PrivilegedGroups,
+		PrivilegedClusterRoles: conf.PrivilegedClusterRoles,
+	}
+
+	registerRatioController(mgr, conf, conf.OrganizationLabel, psk)
 	registerOrganizationRBACController(mgr, conf.OrganizationLabel, conf.DefaultOrganizationClusterRoles)
 	registerZoneK8sVersionController(mgr, controlAPICluster, upstreamZoneIdentifier)
 
@@ -226,6 +254,10 @@ func main() {
 			setupLog.Error(err, "unable to create controller", "controller", "ratio")
 			os.Exit(1)
 		}
+		var applyRateLimiter *rate.Limiter
+		if usageProfileApplyRate > 0 {
+			applyRateLimiter = rate.NewLimiter(rate.Limit(usageProfileApplyRate), max(usageProfileApplyConcurrency, 1))
+		}
 		if err := (&controllers.ZoneUsageProfileApplyReconciler{
 			Client:   mgr.GetClient(),
 			Scheme:   mgr.GetScheme(),
@@ -233,15 +265,31 @@ func main() {
 			Cache:    mgr.GetCache(),
 
 			OrganizationLabel: conf.OrganizationLabel,
-			Transformers: []transformers.Transformer{
-				transformers.NewResourceQuotaTransformer("resourcequota.appuio.io"),
-			},
+			Transformers:      usageProfileTransformers(conf, upstreamZoneIdentifier),
 
-			SelectedProfile: selectedUsageProfile,
+			SelectedProfile:   selectedUsageProfile,
+			UsageProfileLabel: conf.UsageProfileLabel,
+			DisablePruning:    disableUsageProfilePruning,
+
+			MaxConcurrentNamespaces: usageProfileApplyConcurrency,
+			RateLimiter:             applyRateLimiter,
+			Rollout:                 conf.UsageProfileRollout,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "ratio")
 			os.Exit(1)
 		}
+		if conf.UsageProfileLabel != "" {
+			if err := (&controllers.OrganizationUsageProfileSyncReconciler{
+				Client:        mgr.GetClient(),
+				ForeignClient: controlAPICluster.GetClient(),
+
+				OrganizationLabel: conf.OrganizationLabel,
+				UsageProfileLabel: conf.UsageProfileLabel,
+			}).SetupWithManagerAndForeignCluster(mgr, controlAPICluster); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "organization-usage-profile-sync")
+				os.Exit(1)
+			}
+		}
 	}
 	if legacyResourceQuotaEnabled {
 		if err := (&controllers.LegacyResourceQuotaReconciler{
@@ -260,20 +308,45 @@ func main() {
 		}
 	}
 
-	psk := &skipper.PrivilegedUserSkipper{
-		Client: mgr.GetClient(),
+	if !disableUsageProfiles || legacyNamespaceQuotaEnabled {
+		if err := (&controllers.OrganizationQuotaUsageReconciler{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("organization-quota-usage-controller"),
 
-		PrivilegedUsers:        append(conf.PrivilegedUsers, whoami(mgr).Username),
-		PrivilegedGroups:       conf.PrivilegedGroups,
-		PrivilegedClusterRoles: conf.PrivilegedClusterRoles,
+			OrganizationLabel: conf.OrganizationLabel,
+			Resolver: nsquota.Resolver{
+				Client:                     mgr.GetClient(),
+				SelectedProfile:            selectedUsageProfile,
+				UsageProfileLabel:          conf.UsageProfileLabel,
+				OrganizationLabel:          conf.OrganizationLabel,
+				QuotaOverrideNamespace:     conf.QuotaOverrideNamespace,
+				EnableLegacyNamespaceQuota: legacyNamespaceQuotaEnabled,
+				LegacyNamespaceQuota:       conf.LegacyNamespaceQuota,
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "organization-quota-usage")
+			os.Exit(1)
+		}
 	}
 
 	registerNodeSelectorValidationWebhooks(mgr, conf)
 
+	namespaceReservations := &webhooks.NamespaceReservations{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Namespace: conf.QuotaOverrideNamespace,
+	}
+	if err := mgr.Add(namespaceReservations); err != nil {
+		setupLog.Error(err, "unable to add namespace reservation pruning")
+		os.Exit(1)
+	}
 	mgr.GetWebhookServer().Register("/validate-namespace-quota", &webhook.Admission{
 		Handler: &webhooks.NamespaceQuotaValidator{
-			Client:  mgr.GetClient(),
-			Decoder: admission.NewDecoder(mgr.GetScheme()),
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Decoder:   admission.NewDecoder(mgr.GetScheme()),
+
+			Reservations: namespaceReservations,
 
 			Skipper: psk,
 
@@ -283,10 +356,13 @@ func main() {
 			UserDefaultOrganizationAnnotation: conf.UserDefaultOrganizationAnnotation,
 
 			SelectedProfile:        selectedUsageProfile,
+			UsageProfileLabel:      conf.UsageProfileLabel,
 			QuotaOverrideNamespace: conf.QuotaOverrideNamespace,
 
 			EnableLegacyNamespaceQuota: legacyNamespaceQuotaEnabled,
 			LegacyNamespaceQuota:       conf.LegacyNamespaceQuota,
+
+			WarnUsagePercentage: conf.NamespaceQuotaWarnUsagePercentage,
 		},
 	})
 
@@ -339,6 +415,10 @@ func main() {
 			ReservedNamespaces: conf.ReservedNamespaces,
 			AllowedAnnotations: conf.AllowedAnnotations,
 			AllowedLabels:      conf.AllowedLabels,
+			ProtectedAnnotations: []string{
+				ratio.LimitOverrideAnnotation,
+				ratio.BelowHardLimitSinceAnnotation,
+			},
 		},
 	})
 
@@ -381,6 +461,14 @@ func main() {
 	}
 }
 
+// usageProfileTransformers returns the transformers applied to the resources of ZoneUsageProfiles.
+func usageProfileTransformers(conf Config, upstreamZoneIdentifier string) []transformers.Transformer {
+	return []transformers.Transformer{
+		transformers.NewTemplateTransformer(conf.OrganizationLabel, upstreamZoneIdentifier),
+		transformers.NewResourceQuotaTransformer("resourcequota.appuio.io"),
+	}
+}
+
 func whoami(mgr manager.Manager) authenticationv1.UserInfo {
 	wc, err := whoamicli.WhoamiForConfigAndClient(mgr.GetConfig(), mgr.GetHTTPClient())
 	if err != nil {
@@ -426,18 +514,53 @@ func registerOrganizationRBACController(mgr ctrl.Manager, orgLabel string, defau
 	}
 }
 
-func registerRatioController(mgr ctrl.Manager, conf Config, orgLabel string) {
+func registerRatioController(mgr ctrl.Manager, conf Config, orgLabel string, psk skipper.Skipper) {
+	nodeClassResolver := ratio.NodeClassResolver{
+		Client:          mgr.GetClient(),
+		NodeClassLabels: conf.MemoryPerCoreNodeClassLabels,
+	}
+	extractors := conf.RatioPodTemplateExtractors()
+	index := &ratio.Index{
+		NodeClassResolver:     nodeClassResolver,
+		PodTemplateExtractors: extractors,
+	}
+	warner, err := conf.RatioWarner()
+	if err != nil {
+		setupLog.Error(err, "unable to parse ratio warning templates")
+		os.Exit(1)
+	}
+	if err := index.SetupWithInformer(context.Background(), mgr.GetCache()); err != nil {
+		setupLog.Error(err, "unable to setup ratio index")
+		os.Exit(1)
+	}
+
 	mgr.GetWebhookServer().Register("/validate-request-ratio", &webhook.Admission{
 		Handler: &webhooks.RatioValidator{
 			DefaultNodeSelector:                    conf.DefaultNodeSelector,
 			DefaultNamespaceNodeSelectorAnnotation: conf.DefaultNamespaceNodeSelectorAnnotation,
 
-			Decoder: admission.NewDecoder(mgr.GetScheme()),
-			Client:  mgr.GetClient(),
+			Decoder:   admission.NewDecoder(mgr.GetScheme()),
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
 
-			RatioLimits: conf.MemoryPerCoreLimits,
+			Skipper: psk,
+
+			NodeClassResolver: nodeClassResolver,
+			Warner:            warner,
+			LimitAnalysis:     conf.MemoryPerCoreLimitAnalysis,
+
+			PodTemplateExtractors: extractors,
+
+			RatioLimits:       conf.MemoryPerCoreLimits,
+			OrganizationLabel: orgLabel,
+			Aggregation:       conf.MemoryPerCoreAggregation,
 			Ratio: &ratio.Fetcher{
-				Client: mgr.GetClient(),
+				Client:                mgr.GetClient(),
+				NodeClassResolver:     nodeClassResolver,
+				PodTemplateExtractors: extractors,
+				Aggregation:           conf.MemoryPerCoreAggregation,
+				AggregationLabel:      orgLabel,
+				Index:                 index,
 			},
 			RatioWarnThreshold: conf.MemoryPerCoreWarnThreshold,
 		},
@@ -449,10 +572,18 @@ func registerRatioController(mgr ctrl.Manager, conf Config, orgLabel string) {
 		Scheme:      mgr.GetScheme(),
 		RatioLimits: conf.MemoryPerCoreLimits,
 		Ratio: &ratio.Fetcher{
-			Client:            mgr.GetClient(),
-			OrganizationLabel: orgLabel,
+			Client:                mgr.GetClient(),
+			OrganizationLabel:     orgLabel,
+			NodeClassResolver:     nodeClassResolver,
+			PodTemplateExtractors: extractors,
+			Aggregation:           conf.MemoryPerCoreAggregation,
+			AggregationLabel:      orgLabel,
+			Index:                 index,
 		},
 		RatioWarnThreshold: conf.MemoryPerCoreWarnThreshold,
+		OrganizationLabel:  orgLabel,
+		Aggregation:        conf.MemoryPerCoreAggregation,
+		Warner:             warner,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ratio")
 		os.Exit(1)
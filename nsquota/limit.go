@@ -0,0 +1,157 @@
+// Package nsquota resolves the ZoneUsageProfile of organizations and the maximum number of namespaces an organization is allowed to create.
+package nsquota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+)
+
+// Source is the source of the namespace quota of an organization.
+type Source string
+
+const (
+	// SourceZoneUsageProfile is the namespace count of the selected ZoneUsageProfile.
+	SourceZoneUsageProfile Source = "ZoneUsageProfile"
+	// SourceLegacy is the legacy namespace quota.
+	SourceLegacy Source = "Legacy"
+	// SourceOrganizationQuotaOverride is an active OrganizationQuotaOverride of the organization.
+	SourceOrganizationQuotaOverride Source = "OrganizationQuotaOverride"
+	// SourceOverrideConfigMap is the deprecated override ConfigMap of the organization.
+	SourceOverrideConfigMap Source = "OverrideConfigMap"
+)
+
+// ErrNoProfileSelected is returned if neither the legacy namespace quota is enabled nor a ZoneUsageProfile is selected.
+var ErrNoProfileSelected = errors.New("no ZoneUsageProfile selected")
+
+// Limit is the namespace quota of an organization.
+type Limit struct {
+	// Namespaces is the maximum number of namespaces of the organization.
+	Namespaces int
+	// Source is where the quota is defined.
+	Source Source
+}
+
+// Resolver resolves the namespace quota of organizations.
+type Resolver struct {
+	Client client.Reader
+
+	// SelectedProfile is the name of the ZoneUsageProfile to use for the quota
+	// of organizations not selecting a profile with the UsageProfileLabel.
+	SelectedProfile string
+	// UsageProfileLabel is the label on the namespaces of an organization selecting the ZoneUsageProfile of the organization.
+	// Organizations can't select a profile if empty.
+	UsageProfileLabel string
+	// OrganizationLabel is the label identifying the namespaces of an organization.
+	// Required if UsageProfileLabel is set.
+	OrganizationLabel string
+
+	// QuotaOverrideNamespace is the namespace in which the deprecated quota override ConfigMaps are stored.
+	QuotaOverrideNamespace string
+
+	// EnableLegacyNamespaceQuota enables the legacy namespace quota.
+	EnableLegacyNamespaceQuota bool
+	// LegacyNamespaceQuota is the namespace quota for legacy mode.
+	LegacyNamespaceQuota int
+}
+
+// Limit returns the namespace quota of the given organization.
+// An active OrganizationQuotaOverride takes precedence over the deprecated override ConfigMap,
+// which takes precedence over the legacy quota or the selected ZoneUsageProfile.
+// Returns ErrNoProfileSelected if no default quota is configured, even if the organization has an override.
+func (r Resolver) Limit(ctx context.Context, organization string) (Limit, error) {
+	var limit Limit
+	if r.EnableLegacyNamespaceQuota {
+		limit = Limit{Namespaces: r.LegacyNamespaceQuota, Source: SourceLegacy}
+	} else {
+		selected, err := r.Profile(ctx, organization)
+		if err != nil {
+			return Limit{}, err
+		}
+		if selected == "" {
+			return Limit{}, ErrNoProfileSelected
+		}
+
+		var profile cloudagentv1.ZoneUsageProfile
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: selected}, &profile); err != nil {
+			return Limit{}, fmt.Errorf("error while fetching zone usage profile: %w", err)
+		}
+		limit = Limit{Namespaces: profile.Spec.UpstreamSpec.NamespaceCount, Source: SourceZoneUsageProfile}
+	}
+
+	var override cloudagentv1.OrganizationQuotaOverride
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: organization}, &override); err == nil {
+		if override.Active(time.Now()) && override.Spec.NamespaceQuota != nil {
+			return Limit{Namespaces: *override.Spec.NamespaceQuota, Source: SourceOrganizationQuotaOverride}, nil
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return Limit{}, fmt.Errorf("error while fetching organization quota override: %w", err)
+	}
+
+	var overrideCM corev1.ConfigMap
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: OverrideConfigMapName(organization), Namespace: r.QuotaOverrideNamespace}, &overrideCM); err != nil {
+		if apierrors.IsNotFound(err) {
+			return limit, nil
+		}
+		return Limit{}, fmt.Errorf("error while fetching override configmap: %w", err)
+	}
+	if overrideCM.Data["namespaceQuota"] == "" {
+		return limit, nil
+	}
+	quota, err := strconv.Atoi(overrideCM.Data["namespaceQuota"])
+	if err != nil {
+		return Limit{}, fmt.Errorf("error while parsing namespace quota of override configmap: %w", err)
+	}
+	return Limit{Namespaces: quota, Source: SourceOverrideConfigMap}, nil
+}
+
+// Profile returns the name of the ZoneUsageProfile selected by the given organization.
+// The profile is selected by the UsageProfileLabel of the namespaces of the organization.
+// If the namespaces select different profiles, the profile selected by the first namespace in alphabetical order is used.
+// Returns the SelectedProfile if the organization does not select a profile.
+func (r Resolver) Profile(ctx context.Context, organization string) (string, error) {
+	if r.UsageProfileLabel == "" {
+		return r.SelectedProfile, nil
+	}
+
+	var nsList corev1.NamespaceList
+	if err := r.Client.List(ctx, &nsList, client.MatchingLabels{r.OrganizationLabel: organization}, client.HasLabels{r.UsageProfileLabel}); err != nil {
+		return "", fmt.Errorf("error while listing namespaces: %w", err)
+	}
+	selected := r.SelectedProfile
+	first := ""
+	for _, ns := range nsList.Items {
+		if p := ns.Labels[r.UsageProfileLabel]; p != "" && (first == "" || ns.Name < first) {
+			first = ns.Name
+			selected = p
+		}
+	}
+	return selected, nil
+}
+
+// NamespaceProfile returns the name of the ZoneUsageProfile selected by the usageProfileLabel of the given namespace.
+// Returns defaultProfile if the label is not set or usageProfileLabel is empty.
+func NamespaceProfile(ns metav1.Object, usageProfileLabel, defaultProfile string) string {
+	if usageProfileLabel == "" {
+		return defaultProfile
+	}
+	if p := ns.GetLabels()[usageProfileLabel]; p != "" {
+		return p
+	}
+	return defaultProfile
+}
+
+// OverrideConfigMapName returns the name of the deprecated override ConfigMap of the given organization.
+func OverrideConfigMapName(organization string) string {
+	return "override-" + organization
+}
@@ -0,0 +1,184 @@
+package nsquota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	controlv1 "github.com/appuio/control-api/apis/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+)
+
+func TestResolver_Limit(t *testing.T) {
+	quota := func(q int) *int { return &q }
+	profile := &cloudagentv1.ZoneUsageProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "profile"},
+		Spec: cloudagentv1.ZoneUsageProfileSpec{
+			UpstreamSpec: controlv1.UsageProfileSpec{NamespaceCount: 5},
+		},
+	}
+	override := func(q *int, expiresAt *metav1.Time) *cloudagentv1.OrganizationQuotaOverride {
+		return &cloudagentv1.OrganizationQuotaOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme"},
+			Spec: cloudagentv1.OrganizationQuotaOverrideSpec{
+				Reason:         "test",
+				ExpiresAt:      expiresAt,
+				NamespaceQuota: q,
+			},
+		}
+	}
+	overrideCM := func(q string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "override-acme", Namespace: "overrides"},
+			Data:       map[string]string{"namespaceQuota": q},
+		}
+	}
+
+	tcs := map[string]struct {
+		objs   []client.Object
+		legacy bool
+		noProf bool
+
+		expected Limit
+		err      error
+		anyErr   bool
+	}{
+		"profile": {
+			objs:     []client.Object{profile},
+			expected: Limit{Namespaces: 5, Source: SourceZoneUsageProfile},
+		},
+		"legacy": {
+			legacy:   true,
+			expected: Limit{Namespaces: 3, Source: SourceLegacy},
+		},
+		"no profile selected": {
+			noProf: true,
+			objs:   []client.Object{override(quota(10), nil)},
+			err:    ErrNoProfileSelected,
+		},
+		"profile missing": {
+			anyErr: true,
+		},
+		"override": {
+			objs:     []client.Object{profile, override(quota(10), nil), overrideCM("20")},
+			expected: Limit{Namespaces: 10, Source: SourceOrganizationQuotaOverride},
+		},
+		"override without namespace quota": {
+			objs:     []client.Object{profile, override(nil, nil)},
+			expected: Limit{Namespaces: 5, Source: SourceZoneUsageProfile},
+		},
+		"expired override": {
+			objs:     []client.Object{profile, override(quota(10), &metav1.Time{Time: time.Now().Add(-time.Minute)}), overrideCM("20")},
+			expected: Limit{Namespaces: 20, Source: SourceOverrideConfigMap},
+		},
+		"override configmap": {
+			legacy:   true,
+			objs:     []client.Object{overrideCM("20")},
+			expected: Limit{Namespaces: 20, Source: SourceOverrideConfigMap},
+		},
+		"invalid override configmap": {
+			objs:   []client.Object{profile, overrideCM("twenty")},
+			anyErr: true,
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, cloudagentv1.AddToScheme(scheme))
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.objs...).Build()
+
+			subject := Resolver{
+				Client:                     c,
+				SelectedProfile:            "profile",
+				QuotaOverrideNamespace:     "overrides",
+				EnableLegacyNamespaceQuota: tc.legacy,
+				LegacyNamespaceQuota:       3,
+			}
+			if tc.noProf {
+				subject.SelectedProfile = ""
+			}
+
+			l, err := subject.Limit(context.Background(), "acme")
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			if tc.anyErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, l)
+		})
+	}
+}
+
+func TestResolver_Profile(t *testing.T) {
+	const orgLabel = "appuio.io/organization"
+	const profileLabel = "appuio.io/usage-profile"
+	ns := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, cloudagentv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		ns("acme-b", map[string]string{orgLabel: "acme", profileLabel: "large"}),
+		ns("acme-a", map[string]string{orgLabel: "acme"}),
+		ns("acme-c", map[string]string{orgLabel: "acme", profileLabel: "medium"}),
+		ns("other", map[string]string{orgLabel: "other"}),
+		&cloudagentv1.ZoneUsageProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: "large"},
+			Spec: cloudagentv1.ZoneUsageProfileSpec{
+				UpstreamSpec: controlv1.UsageProfileSpec{NamespaceCount: 50},
+			},
+		},
+	).Build()
+
+	subject := Resolver{
+		Client:            c,
+		SelectedProfile:   "default",
+		UsageProfileLabel: profileLabel,
+		OrganizationLabel: orgLabel,
+	}
+
+	p, err := subject.Profile(context.Background(), "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "large", p, "should use the profile of the first namespace selecting a profile")
+	p, err = subject.Profile(context.Background(), "other")
+	require.NoError(t, err)
+	assert.Equal(t, "default", p)
+
+	l, err := subject.Limit(context.Background(), "acme")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Namespaces: 50, Source: SourceZoneUsageProfile}, l)
+
+	subject.SelectedProfile = ""
+	_, err = subject.Limit(context.Background(), "other")
+	require.ErrorIs(t, err, ErrNoProfileSelected)
+
+	subject.UsageProfileLabel = ""
+	subject.SelectedProfile = "default"
+	p, err = subject.Profile(context.Background(), "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "default", p, "should ignore labels if selection is disabled")
+}
+
+func TestNamespaceProfile(t *testing.T) {
+	ns := &metav1.ObjectMeta{Labels: map[string]string{"profile": "large"}}
+	assert.Equal(t, "large", NamespaceProfile(ns, "profile", "default"))
+	assert.Equal(t, "default", NamespaceProfile(&metav1.ObjectMeta{}, "profile", "default"))
+	assert.Equal(t, "default", NamespaceProfile(ns, "", "default"))
+}
@@ -0,0 +1,105 @@
+package ratio
+
+import (
+	"fmt"
+	"strings"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// PodAnalysis is the result of analysing the resource specification of a pod for requests and limits distorting the ratio.
+type PodAnalysis struct {
+	// QOSClass is the quality of service class of the pod.
+	QOSClass corev1.PodQOSClass
+	// MissingCPURequests are the names of the containers without CPU request.
+	MissingCPURequests []string
+	// MissingMemoryRequests are the names of the containers without memory request.
+	MissingMemoryRequests []string
+	// Limits is the memory to CPU ratio of the limits of the pod.
+	// Nil if not all containers have CPU and memory limits.
+	Limits *Ratio
+}
+
+// AnalyzePod analyses the requests and limits of the given pod spec.
+// Containers with a limit but no request are not considered missing a request, since the request defaults to the limit.
+func AnalyzePod(spec corev1.PodSpec) PodAnalysis {
+	a := PodAnalysis{}
+
+	containers := make([]corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+
+	hasAny := false
+	guaranteed := true
+	limited := true
+	for _, c := range containers {
+		for _, res := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			req, hasReq := c.Resources.Requests[res]
+			lim, hasLim := c.Resources.Limits[res]
+			hasAny = hasAny || hasReq || hasLim
+			limited = limited && hasLim
+			if !hasLim || (hasReq && req.Cmp(lim) != 0) {
+				guaranteed = false
+			}
+			if hasReq || hasLim {
+				continue
+			}
+			if res == corev1.ResourceCPU {
+				a.MissingCPURequests = append(a.MissingCPURequests, c.Name)
+			} else {
+				a.MissingMemoryRequests = append(a.MissingMemoryRequests, c.Name)
+			}
+		}
+	}
+
+	switch {
+	case !hasAny:
+		a.QOSClass = corev1.PodQOSBestEffort
+	case guaranteed:
+		a.QOSClass = corev1.PodQOSGuaranteed
+	default:
+		a.QOSClass = corev1.PodQOSBurstable
+	}
+
+	if limited && len(containers) > 0 {
+		lims := PodLimits(spec)
+		a.Limits = &Ratio{
+			CPU:    inf.NewDec(0, 0).Set(lims.Cpu().AsDec()),
+			Memory: inf.NewDec(0, 0).Set(lims.Memory().AsDec()),
+		}
+	}
+	return a
+}
+
+// Warnings returns warnings for requests and limits distorting the ratio.
+// object describes the analysed object in the warnings, e.g. `Deployment "foo"`.
+// The limit ratio is compared to the given fair use limit if it is not nil.
+func (a PodAnalysis) Warnings(object string, limit *resource.Quantity) []string {
+	// WARNING(glrf) Warnings MUST NOT contain newlines. K8s will simply drop your warning if you add newlines.
+	warnings := make([]string, 0, 3)
+	if a.QOSClass == corev1.PodQOSBestEffort {
+		warnings = append(warnings, fmt.Sprintf("%s has no CPU and memory requests and runs as BestEffort."+
+			" Pods without requests can be evicted at any time and distort scheduling and the fair use ratio."+
+			" Please set CPU and memory requests for all containers.", object))
+	} else {
+		if len(a.MissingCPURequests) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s has containers without CPU requests: %s."+
+				" Containers without requests distort scheduling and the fair use ratio. Please set CPU requests for all containers.",
+				object, strings.Join(a.MissingCPURequests, ", ")))
+		}
+		if len(a.MissingMemoryRequests) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s has containers without memory requests: %s."+
+				" Containers without requests distort scheduling and the fair use ratio. Please set memory requests for all containers.",
+				object, strings.Join(a.MissingMemoryRequests, ", ")))
+		}
+	}
+
+	if limit != nil && a.Limits != nil && a.Limits.Below(*limit, nil) {
+		warnings = append(warnings, fmt.Sprintf("Memory to CPU limit ratio of %s/core of %s is below the fair use ratio of %s/core."+
+			" Limits out of proportion to the fair use ratio can lead to excessive CPU usage. Please adjust the limits.",
+			a.Limits, object, limit))
+	}
+	return warnings
+}
@@ -0,0 +1,121 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestAnalyzePod(t *testing.T) {
+	container := func(name string, requests, limits corev1.ResourceList) corev1.Container {
+		return corev1.Container{
+			Name:      name,
+			Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits},
+		}
+	}
+	resources := func(cpu, memory string) corev1.ResourceList {
+		l := corev1.ResourceList{}
+		if cpu != "" {
+			l[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			l[corev1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return l
+	}
+
+	tcs := map[string]struct {
+		spec          corev1.PodSpec
+		qos           corev1.PodQOSClass
+		missingCPU    []string
+		missingMemory []string
+		limitCPU      string
+		limitMemory   string
+		warnings      int
+	}{
+		"best effort": {
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{container("app", nil, nil), container("proxy", nil, nil)},
+			},
+			qos:           corev1.PodQOSBestEffort,
+			missingCPU:    []string{"app", "proxy"},
+			missingMemory: []string{"app", "proxy"},
+			warnings:      1,
+		},
+		"burstable without cpu requests": {
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					container("app", resources("", "1Gi"), nil),
+					container("proxy", resources("100m", "100Mi"), nil),
+				},
+			},
+			qos:        corev1.PodQOSBurstable,
+			missingCPU: []string{"app"},
+			warnings:   1,
+		},
+		"burstable without memory requests in init container": {
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{container("init", resources("100m", ""), nil)},
+				Containers:     []corev1.Container{container("app", resources("1", "4Gi"), nil)},
+			},
+			qos:           corev1.PodQOSBurstable,
+			missingMemory: []string{"init"},
+			warnings:      1,
+		},
+		"requests default to limits": {
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{container("app", nil, resources("1", "4Gi"))},
+			},
+			qos:         corev1.PodQOSGuaranteed,
+			limitCPU:    "1",
+			limitMemory: "4Gi",
+		},
+		"limits out of proportion": {
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					container("app", resources("1", "4Gi"), resources("4", "4Gi")),
+					container("proxy", resources("100m", "1Gi"), resources("4", "1Gi")),
+				},
+			},
+			qos:         corev1.PodQOSBurstable,
+			limitCPU:    "8",
+			limitMemory: "5Gi",
+			warnings:    1,
+		},
+		"partial limits": {
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					container("app", resources("1", "4Gi"), resources("", "4Gi")),
+				},
+			},
+			qos: corev1.PodQOSBurstable,
+		},
+	}
+
+	limit := resource.MustParse("4Gi")
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			a := AnalyzePod(tc.spec)
+			assert.Equal(t, tc.qos, a.QOSClass)
+			assert.Equal(t, tc.missingCPU, a.MissingCPURequests)
+			assert.Equal(t, tc.missingMemory, a.MissingMemoryRequests)
+			if tc.limitCPU == "" {
+				assert.Nil(t, a.Limits)
+			} else {
+				require.NotNil(t, a.Limits)
+				assertResourceEqual(t, resource.NewDecimalQuantity(*a.Limits.CPU, resource.DecimalSI), tc.limitCPU)
+				assertResourceEqual(t, resource.NewDecimalQuantity(*a.Limits.Memory, resource.BinarySI), tc.limitMemory)
+			}
+
+			warnings := a.Warnings(`Deployment "foo"`, &limit)
+			assert.Len(t, warnings, tc.warnings)
+			for _, w := range warnings {
+				assert.Contains(t, w, `Deployment "foo"`)
+				assert.NotContains(t, w, "\n")
+			}
+		})
+	}
+}
@@ -3,16 +3,31 @@ package ratio
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/limits"
 )
 
 // RatioValidatiorDisableAnnotation is the key for an annotion on a namespace to disable request ratio warnings
 var RatioValidatiorDisableAnnotation = "validate-request-ratio.appuio.io/disable"
 
+// BelowHardLimitSinceAnnotation is the key for an annotation on a namespace recording since when the namespace is below an enforced hard limit.
+// The value is a RFC3339 timestamp.
+var BelowHardLimitSinceAnnotation = "validate-request-ratio.appuio.io/below-hard-limit-since"
+
+// LimitOverrideAnnotation is the key for an annotation on a namespace overriding the fair use ratio limit for the namespace.
+// The value is a quantity of memory per core, e.g. "2Gi". It replaces the limit of all node selectors.
+var LimitOverrideAnnotation = "validate-request-ratio.appuio.io/limit"
+
 // ErrorDisabled is returned if the request ratio validation is disabled
 var ErrorDisabled error = errors.New("request ratio validation disabled")
 
@@ -21,9 +36,41 @@ type Fetcher struct {
 	Client client.Client
 
 	OrganizationLabel string
+
+	// PodTemplateExtractors are used to extract the requests from pods.
+	// Defaults to DefaultPodTemplateExtractors if nil.
+	PodTemplateExtractors PodTemplateExtractors
+
+	// NodeClassResolver resolves the node selector pods are grouped by.
+	// Only `.spec.nodeSelector` is used if unset.
+	NodeClassResolver NodeClassResolver
+
+	// Aggregation defines the scope the ratio is aggregated over.
+	// Defaults to AggregationNamespace.
+	Aggregation Aggregation
+	// AggregationLabel is the namespace label identifying the organization of a namespace for AggregationOrganization.
+	// Namespaces without the label are aggregated per namespace.
+	AggregationLabel string
+
+	// Index is used to look up the ratios of a namespace if set and synced.
+	// Pods are listed using the Client otherwise.
+	Index *Index
 }
 
-// FetchRatios collects the CPU to memory request ratio for the given namespace grouped by `.spec.nodeSelector`.
+// Aggregation defines the scope the ratio is aggregated over.
+type Aggregation string
+
+const (
+	// AggregationNamespace aggregates the ratio per namespace. This is the default.
+	AggregationNamespace Aggregation = "Namespace"
+	// AggregationOrganization aggregates the ratio over all namespaces of an organization.
+	AggregationOrganization Aggregation = "Organization"
+)
+
+var podGroupKind = corev1.SchemeGroupVersion.WithKind("Pod").GroupKind()
+
+// FetchRatios collects the CPU to memory request ratio for the given namespace grouped by the node selector resolved by the NodeClassResolver.
+// If Aggregation is AggregationOrganization, the ratios of all enabled namespaces of the organization are summed up.
 func (f Fetcher) FetchRatios(ctx context.Context, name string) (map[string]*Ratio, error) {
 	ns := corev1.Namespace{}
 	err := f.Client.Get(ctx, client.ObjectKey{
@@ -33,34 +80,150 @@ func (f Fetcher) FetchRatios(ctx context.Context, name string) (map[string]*Rati
 		return nil, err
 	}
 
+	if f.disabled(ns) {
+		return nil, ErrorDisabled
+	}
+
+	org := AggregatedOrganization(f.Aggregation, f.AggregationLabel, ns)
+	if org == "" {
+		return f.namespaceRatios(ctx, name)
+	}
+
+	orgNamespaces := corev1.NamespaceList{}
+	if err := f.Client.List(ctx, &orgNamespaces, client.MatchingLabels{f.AggregationLabel: org}); err != nil {
+		return nil, err
+	}
+	ratios := make(map[string]*Ratio)
+	for _, orgNs := range orgNamespaces.Items {
+		if f.disabled(orgNs) {
+			continue
+		}
+		nsRatios, err := f.namespaceRatios(ctx, orgNs.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch ratios of namespace %q: %w", orgNs.Name, err)
+		}
+		for k, nr := range nsRatios {
+			r, ok := ratios[k]
+			if !ok {
+				r = NewRatio()
+				r.Organization = org
+				ratios[k] = r
+			}
+			r.CPU.Add(r.CPU, nr.CPU)
+			r.Memory.Add(r.Memory, nr.Memory)
+		}
+	}
+	return ratios, nil
+}
+
+// AggregatedOrganization returns the organization the ratio of the given namespace is aggregated over.
+// Returns an empty string if the ratio is aggregated per namespace, either because of the aggregation or because the namespace has no organization label.
+func AggregatedOrganization(aggregation Aggregation, organizationLabel string, ns corev1.Namespace) string {
+	if aggregation != AggregationOrganization || organizationLabel == "" {
+		return ""
+	}
+	return ns.Labels[organizationLabel]
+}
+
+// NamespaceLimits returns the limits applicable to the given namespace.
+// The limits are overridden if the namespace has the LimitOverrideAnnotation.
+func NamespaceLimits(l limits.Limits, ns corev1.Namespace) (limits.Limits, error) {
+	override, ok := ns.Annotations[LimitOverrideAnnotation]
+	if !ok {
+		return l, nil
+	}
+	q, err := resource.ParseQuantity(override)
+	if err != nil {
+		return l, fmt.Errorf("invalid %s annotation %q: %w", LimitOverrideAnnotation, override, err)
+	}
+	return l.WithOverride(q), nil
+}
+
+// OrganizationNamespaceLimits returns the limits applicable to the given namespace.
+// The limits are overridden by the MemoryPerCoreLimit of an active OrganizationQuotaOverride of the organization of the namespace.
+// The LimitOverrideAnnotation of the namespace takes precedence over the organization override.
+// Organization overrides are ignored if organizationLabel is empty.
+func OrganizationNamespaceLimits(ctx context.Context, c client.Reader, organizationLabel string, l limits.Limits, ns corev1.Namespace) (limits.Limits, error) {
+	if org := ns.Labels[organizationLabel]; organizationLabel != "" && org != "" {
+		var err error
+		l, err = OrganizationLimits(ctx, c, org, l)
+		if err != nil {
+			return l, err
+		}
+	}
+	return NamespaceLimits(l, ns)
+}
+
+// OrganizationLimits returns the limits applicable to the given organization.
+// The limits are overridden by the MemoryPerCoreLimit of an active OrganizationQuotaOverride of the organization.
+// Used for ratios aggregated over all namespaces of the organization, the LimitOverrideAnnotation of single namespaces does not apply.
+func OrganizationLimits(ctx context.Context, c client.Reader, organization string, l limits.Limits) (limits.Limits, error) {
+	var override cloudagentv1.OrganizationQuotaOverride
+	err := c.Get(ctx, client.ObjectKey{Name: organization}, &override)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return l, fmt.Errorf("failed to get organization quota override: %w", err)
+	}
+	if err == nil && override.Active(time.Now()) && override.Spec.MemoryPerCoreLimit != nil {
+		l = l.WithOverride(*override.Spec.MemoryPerCoreLimit)
+	}
+	return l, nil
+}
+
+// disabled returns true if the ratio validation is disabled for the given namespace.
+func (f Fetcher) disabled(ns corev1.Namespace) bool {
 	disabledAnnot, ok := ns.Annotations[RatioValidatiorDisableAnnotation]
 	if ok {
 		disabled, err := strconv.ParseBool(disabledAnnot)
 		if err == nil && disabled {
-			return nil, ErrorDisabled
+			return true
 		}
 	}
 
 	if f.OrganizationLabel != "" {
 		if _, isOrgNs := ns.Labels[f.OrganizationLabel]; !isOrgNs {
-			return nil, ErrorDisabled
+			return true
 		}
 	}
+	return false
+}
+
+// namespaceRatios collects the CPU to memory request ratio of a single namespace.
+func (f Fetcher) namespaceRatios(ctx context.Context, name string) (map[string]*Ratio, error) {
+	if f.Index != nil && f.Index.HasSynced() {
+		return f.Index.FetchRatios(ctx, name)
+	}
+
+	extractors := f.PodTemplateExtractors
+	if extractors == nil {
+		extractors = DefaultPodTemplateExtractors()
+	}
+	extractor, ok := extractors.Extractor(podGroupKind)
+	if !ok {
+		return nil, fmt.Errorf("no pod template extractor registered for %s", podGroupKind)
+	}
 
 	pods := corev1.PodList{}
-	err = f.Client.List(ctx, &pods, client.InNamespace(name))
+	err := f.Client.List(ctx, &pods, client.InNamespace(name))
 	if err != nil {
 		return nil, err
 	}
 
 	ratios := make(map[string]*Ratio)
-	for _, pod := range pods.Items {
-		k := labels.Set(pod.Spec.NodeSelector).String()
+	for i := range pods.Items {
+		replicas, spec, ok := extractor.PodTemplate(&pods.Items[i])
+		if !ok {
+			continue
+		}
+		nodeSel, err := f.NodeClassResolver.NodeSelector(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		k := labels.Set(nodeSel).String()
 		r, ok := ratios[k]
 		if !ok {
 			r = NewRatio()
 		}
-		ratios[k] = r.RecordPod(pod)
+		ratios[k] = r.RecordPodTemplate(replicas, spec)
 	}
 
 	return ratios, nil
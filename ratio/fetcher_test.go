@@ -4,18 +4,23 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/limits"
 )
 
 var (
@@ -237,6 +242,156 @@ func TestRatioValidator_Handle(t *testing.T) {
 	}
 }
 
+func TestFetcher_OrganizationAggregation(t *testing.T) {
+	orgNamespace := func(name, org string, disabled bool) *corev1.Namespace {
+		ns := testNamespace(name)
+		ns.Labels = map[string]string{"org": org}
+		if disabled {
+			ns.Annotations = map[string]string{RatioValidatiorDisableAnnotation: "true"}
+		}
+		return ns
+	}
+	pod := func(ns, cpu, memory string) *corev1.Pod {
+		return podFromResources("pod", ns, podResource{
+			containers: []containerResources{{cpu: cpu, memory: memory}},
+			phase:      corev1.PodRunning,
+		})
+	}
+
+	f := prepareTest(t, testCfg{
+		initObjs: []client.Object{
+			orgNamespace("acme-cpu", "acme", false),
+			orgNamespace("acme-memory", "acme", false),
+			orgNamespace("acme-disabled", "acme", true),
+			orgNamespace("other", "other", false),
+			pod("acme-cpu", "4", "1Gi"),
+			pod("acme-memory", "0", "15Gi"),
+			pod("acme-disabled", "10", "1Gi"),
+			pod("other", "1", "1Gi"),
+			fooPod,
+		},
+	})
+	f.Aggregation = AggregationOrganization
+	f.AggregationLabel = "org"
+
+	r, err := f.FetchRatios(context.Background(), "acme-cpu")
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].CPU, resource.BinarySI), "4")
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].Memory, resource.BinarySI), "16Gi")
+	assert.Equal(t, "acme", r[""].Organization)
+	assert.Contains(t, r[""].Warn(nil, ""), `in organization "acme"`)
+
+	r, err = f.FetchRatios(context.Background(), "other")
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].CPU, resource.BinarySI), "1")
+
+	// Namespaces without organization are aggregated per namespace
+	r, err = f.FetchRatios(context.Background(), "foo")
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].CPU, resource.BinarySI), "3")
+	assert.Empty(t, r[""].Organization)
+
+	_, err = f.FetchRatios(context.Background(), "acme-disabled")
+	require.ErrorIs(t, err, ErrorDisabled)
+}
+
+func TestNamespaceLimits(t *testing.T) {
+	defaultLimit := resource.MustParse("4Gi")
+	defaults := limits.Limits{{Limit: &defaultLimit}}
+
+	ns := testNamespace("foo")
+	l, err := NamespaceLimits(defaults, *ns)
+	require.NoError(t, err)
+	assert.Equal(t, "4Gi", l.GetLimitForNodeSelector(nil).String())
+
+	ns.Annotations = map[string]string{LimitOverrideAnnotation: "2Gi"}
+	l, err = NamespaceLimits(defaults, *ns)
+	require.NoError(t, err)
+	assert.Equal(t, "2Gi", l.GetLimitForNodeSelector(nil).String())
+
+	ns.Annotations = map[string]string{LimitOverrideAnnotation: "lots"}
+	l, err = NamespaceLimits(defaults, *ns)
+	require.Error(t, err)
+	assert.Equal(t, "4Gi", l.GetLimitForNodeSelector(nil).String(), "should fall back to default limits")
+}
+
+func TestOrganizationNamespaceLimits(t *testing.T) {
+	defaultLimit := resource.MustParse("4Gi")
+	defaults := limits.Limits{{Limit: &defaultLimit}}
+	orgLimit := resource.MustParse("3Gi")
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(cloudagentv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			&cloudagentv1.OrganizationQuotaOverride{
+				ObjectMeta: metav1.ObjectMeta{Name: "acme"},
+				Spec:       cloudagentv1.OrganizationQuotaOverrideSpec{Reason: "test", MemoryPerCoreLimit: &orgLimit},
+			},
+			&cloudagentv1.OrganizationQuotaOverride{
+				ObjectMeta: metav1.ObjectMeta{Name: "expired"},
+				Spec: cloudagentv1.OrganizationQuotaOverrideSpec{
+					Reason:             "test",
+					MemoryPerCoreLimit: &orgLimit,
+					ExpiresAt:          &metav1.Time{Time: time.Now().Add(-time.Hour)},
+				},
+			},
+		).
+		Build()
+
+	ns := testNamespace("foo")
+	ns.Labels = map[string]string{"org": "acme"}
+	l, err := OrganizationNamespaceLimits(context.Background(), c, "org", defaults, *ns)
+	require.NoError(t, err)
+	assert.Equal(t, "3Gi", l.GetLimitForNodeSelector(nil).String())
+
+	l, err = OrganizationNamespaceLimits(context.Background(), c, "", defaults, *ns)
+	require.NoError(t, err)
+	assert.Equal(t, "4Gi", l.GetLimitForNodeSelector(nil).String(), "should ignore overrides without organization label")
+
+	ns.Annotations = map[string]string{LimitOverrideAnnotation: "2Gi"}
+	l, err = OrganizationNamespaceLimits(context.Background(), c, "org", defaults, *ns)
+	require.NoError(t, err)
+	assert.Equal(t, "2Gi", l.GetLimitForNodeSelector(nil).String(), "namespace override should take precedence")
+
+	for _, org := range []string{"expired", "other"} {
+		ns := testNamespace("bar")
+		ns.Labels = map[string]string{"org": org}
+		l, err = OrganizationNamespaceLimits(context.Background(), c, "org", defaults, *ns)
+		require.NoError(t, err)
+		assert.Equal(t, "4Gi", l.GetLimitForNodeSelector(nil).String(), org)
+	}
+}
+
+func TestOrganizationLimits(t *testing.T) {
+	defaultLimit := resource.MustParse("4Gi")
+	defaults := limits.Limits{{Limit: &defaultLimit}}
+	orgLimit := resource.MustParse("3Gi")
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(cloudagentv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(&cloudagentv1.OrganizationQuotaOverride{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme"},
+			Spec:       cloudagentv1.OrganizationQuotaOverrideSpec{Reason: "test", MemoryPerCoreLimit: &orgLimit},
+		}).
+		Build()
+
+	l, err := OrganizationLimits(context.Background(), c, "acme", defaults)
+	require.NoError(t, err)
+	assert.Equal(t, "3Gi", l.GetLimitForNodeSelector(nil).String())
+
+	l, err = OrganizationLimits(context.Background(), c, "other", defaults)
+	require.NoError(t, err)
+	assert.Equal(t, "4Gi", l.GetLimitForNodeSelector(nil).String())
+}
+
 type testCfg struct {
 	initObjs []client.Object
 	orgLabel string
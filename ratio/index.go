@@ -0,0 +1,311 @@
+package ratio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// ErrorIndexNotSynced is returned if the index has not yet seen all pods and nodes
+var ErrorIndexNotSynced error = errors.New("ratio index not synced")
+
+// Index keeps the CPU to memory request ratio of all namespaces grouped by the node selector resolved by the NodeClassResolver.
+// It is updated incrementally from pod informer events and answers FetchRatios without listing pods.
+// The node classes of scheduled pods are taken from node informer events, pods are re-indexed if the class of their node changes.
+// Index must be registered with the pod and node informers using SetupWithInformer.
+type Index struct {
+	// PodTemplateExtractors are used to extract the requests from pods.
+	// Defaults to DefaultPodTemplateExtractors if nil.
+	PodTemplateExtractors PodTemplateExtractors
+	// NodeClassResolver resolves the node selector pods are grouped by.
+	// The client of the resolver is not used, nodes are taken from the node informer.
+	// Only `.spec.nodeSelector` is used if unset.
+	NodeClassResolver NodeClassResolver
+
+	// extractors are the PodTemplateExtractors resolved on first use.
+	extractors     PodTemplateExtractors
+	extractorsOnce sync.Once
+
+	mu sync.RWMutex
+	// pods contains the last recorded contribution of every pod.
+	pods map[types.NamespacedName]podContribution
+	// namespaces contains the ratios per node selector and namespace.
+	namespaces map[string]map[string]*indexedRatio
+	// nodeClasses contains the node class labels of every node.
+	nodeClasses map[string]map[string]string
+
+	registration toolscache.ResourceEventHandlerRegistration
+	// nodeRegistration is nil if no NodeClassLabels are configured.
+	nodeRegistration toolscache.ResourceEventHandlerRegistration
+}
+
+type podContribution struct {
+	// pod is the recorded pod, used to re-index the pod if the class of its node changes.
+	pod          *corev1.Pod
+	nodeSelector string
+	cpu          *inf.Dec
+	memory       *inf.Dec
+}
+
+type indexedRatio struct {
+	Ratio
+	// pods is the number of pods recorded for the node selector.
+	// The ratio is removed if no pods are left.
+	pods int
+}
+
+var _ toolscache.ResourceEventHandler = &Index{}
+
+// SetupWithInformer registers the index with the pod informer of the given cache.
+// The index is registered with the node informer if NodeClassLabels are configured.
+func (i *Index) SetupWithInformer(ctx context.Context, c cache.Cache) error {
+	var nodeReg toolscache.ResourceEventHandlerRegistration
+	if len(i.NodeClassResolver.NodeClassLabels) > 0 {
+		nodeInformer, err := c.GetInformer(ctx, &corev1.Node{})
+		if err != nil {
+			return fmt.Errorf("unable to get node informer: %w", err)
+		}
+		nodeReg, err = nodeInformer.AddEventHandler(indexNodeHandler{i})
+		if err != nil {
+			return fmt.Errorf("unable to add event handler to node informer: %w", err)
+		}
+	}
+
+	informer, err := c.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("unable to get pod informer: %w", err)
+	}
+	reg, err := informer.AddEventHandler(i)
+	if err != nil {
+		return fmt.Errorf("unable to add event handler to pod informer: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.registration = reg
+	i.nodeRegistration = nodeReg
+	return nil
+}
+
+// HasSynced returns true if the index has seen all pods and nodes present when the informers started.
+func (i *Index) HasSynced() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.registration != nil && i.registration.HasSynced() &&
+		(i.nodeRegistration == nil || i.nodeRegistration.HasSynced())
+}
+
+// FetchRatios returns the CPU to memory request ratio for the given namespace grouped by the resolved node selector.
+// The returned ratios are copies and can be modified by the caller.
+// Returns ErrorIndexNotSynced if the index has not yet seen all pods.
+func (i *Index) FetchRatios(_ context.Context, name string) (map[string]*Ratio, error) {
+	if !i.HasSynced() {
+		return nil, ErrorIndexNotSynced
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	ratios := make(map[string]*Ratio, len(i.namespaces[name]))
+	for k, r := range i.namespaces[name] {
+		ratios[k] = &Ratio{
+			CPU:    inf.NewDec(0, 0).Set(r.CPU),
+			Memory: inf.NewDec(0, 0).Set(r.Memory),
+		}
+	}
+	return ratios, nil
+}
+
+// OnAdd implements toolscache.ResourceEventHandler
+func (i *Index) OnAdd(obj interface{}, _ bool) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	i.record(pod)
+}
+
+// OnUpdate implements toolscache.ResourceEventHandler
+func (i *Index) OnUpdate(_, newObj interface{}) {
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	i.record(pod)
+}
+
+// OnDelete implements toolscache.ResourceEventHandler
+func (i *Index) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name})
+}
+
+// record replaces the recorded contribution of the given pod.
+func (i *Index) record(pod *corev1.Pod) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.recordLocked(pod)
+}
+
+// recordLocked replaces the recorded contribution of the given pod.
+// The caller must hold the write lock.
+func (i *Index) recordLocked(pod *corev1.Pod) {
+	extractor, ok := i.podTemplateExtractors().Extractor(podGroupKind)
+	if !ok {
+		return
+	}
+
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	i.remove(key)
+	replicas, spec, ok := extractor.PodTemplate(pod)
+	if !ok {
+		return
+	}
+
+	nodeSel := i.NodeClassResolver.NodeSelectorForNode(spec, i.nodeClasses[spec.NodeName])
+	r := NewRatio().RecordPodTemplate(replicas, spec)
+	contrib := podContribution{
+		pod:          pod,
+		nodeSelector: labels.Set(nodeSel).String(),
+		cpu:          r.CPU,
+		memory:       r.Memory,
+	}
+
+	if i.pods == nil {
+		i.pods = make(map[types.NamespacedName]podContribution)
+	}
+	if i.namespaces == nil {
+		i.namespaces = make(map[string]map[string]*indexedRatio)
+	}
+	nsRatios, ok := i.namespaces[pod.Namespace]
+	if !ok {
+		nsRatios = make(map[string]*indexedRatio)
+		i.namespaces[pod.Namespace] = nsRatios
+	}
+	ir, ok := nsRatios[contrib.nodeSelector]
+	if !ok {
+		ir = &indexedRatio{Ratio: *NewRatio()}
+		nsRatios[contrib.nodeSelector] = ir
+	}
+
+	ir.CPU.Add(ir.CPU, contrib.cpu)
+	ir.Memory.Add(ir.Memory, contrib.memory)
+	ir.pods++
+	i.pods[key] = contrib
+}
+
+// podTemplateExtractors returns the configured PodTemplateExtractors or the DefaultPodTemplateExtractors if unset.
+// The defaults are built once and reused for all pod events.
+func (i *Index) podTemplateExtractors() PodTemplateExtractors {
+	i.extractorsOnce.Do(func() {
+		i.extractors = i.PodTemplateExtractors
+		if i.extractors == nil {
+			i.extractors = DefaultPodTemplateExtractors()
+		}
+	})
+	return i.extractors
+}
+
+// remove removes the recorded contribution of the given pod.
+// The caller must hold the write lock.
+func (i *Index) remove(key types.NamespacedName) {
+	contrib, ok := i.pods[key]
+	if !ok {
+		return
+	}
+	delete(i.pods, key)
+
+	nsRatios := i.namespaces[key.Namespace]
+	ir, ok := nsRatios[contrib.nodeSelector]
+	if !ok {
+		return
+	}
+	ir.CPU.Sub(ir.CPU, contrib.cpu)
+	ir.Memory.Sub(ir.Memory, contrib.memory)
+	ir.pods--
+	if ir.pods > 0 {
+		return
+	}
+	delete(nsRatios, contrib.nodeSelector)
+	if len(nsRatios) == 0 {
+		delete(i.namespaces, key.Namespace)
+	}
+}
+
+// indexNodeHandler updates the node classes of the index from node informer events.
+type indexNodeHandler struct {
+	*Index
+}
+
+var _ toolscache.ResourceEventHandler = indexNodeHandler{}
+
+// OnAdd implements toolscache.ResourceEventHandler
+func (h indexNodeHandler) OnAdd(obj interface{}, _ bool) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	h.setNodeClass(node.Name, h.NodeClassResolver.ClassLabels(node.Labels))
+}
+
+// OnUpdate implements toolscache.ResourceEventHandler
+func (h indexNodeHandler) OnUpdate(_, newObj interface{}) {
+	h.OnAdd(newObj, false)
+}
+
+// OnDelete implements toolscache.ResourceEventHandler
+// Pods on deleted nodes are removed by their own events, their node class is kept.
+func (h indexNodeHandler) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.nodeClasses, node.Name)
+}
+
+// setNodeClass records the node class labels of the given node and re-indexes the pods on the node if the class changed.
+func (i *Index) setNodeClass(nodeName string, classLabels map[string]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if current, ok := i.nodeClasses[nodeName]; ok && maps.Equal(current, classLabels) {
+		return
+	}
+	if i.nodeClasses == nil {
+		i.nodeClasses = make(map[string]map[string]string)
+	}
+	i.nodeClasses[nodeName] = classLabels
+
+	var onNode []*corev1.Pod
+	for _, contrib := range i.pods {
+		if contrib.pod.Spec.NodeName == nodeName {
+			onNode = append(onNode, contrib.pod)
+		}
+	}
+	for _, pod := range onNode {
+		i.recordLocked(pod)
+	}
+}
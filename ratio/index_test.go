@@ -0,0 +1,142 @@
+package ratio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	subject := &Index{}
+
+	_, err := subject.FetchRatios(ctx, "foo")
+	require.ErrorIs(t, err, ErrorIndexNotSynced)
+	subject.registration = syncedRegistration{}
+
+	subject.OnAdd(fooPod, true)
+	subject.OnAdd(foo2Pod, true)
+	subject.OnAdd(foobarPod, true)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{"": {"3", "3Gi"}})
+	assertIndexRatios(t, subject, "bar", map[string][2]string{"": {"0", "1337Gi"}})
+
+	// Scale up requests and move to a different node selector
+	updated := tap(fooPod, func(p *corev1.Pod) *corev1.Pod {
+		p.Spec.NodeSelector = map[string]string{"class": "mega"}
+		p.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("4")
+		return p
+	})
+	subject.OnUpdate(fooPod, updated)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{
+		"":           {"0", "1Gi"},
+		"class=mega": {"6", "2Gi"},
+	})
+
+	// Completed pods are kept but do not contribute
+	completed := tap(updated, func(p *corev1.Pod) *corev1.Pod {
+		p.Status.Phase = corev1.PodSucceeded
+		return p
+	})
+	subject.OnUpdate(updated, completed)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{
+		"":           {"0", "1Gi"},
+		"class=mega": {"0", "0"},
+	})
+
+	subject.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "foo/foo1", Obj: completed})
+	subject.OnDelete(foo2Pod)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{})
+	assertIndexRatios(t, subject, "bar", map[string][2]string{"": {"0", "1337Gi"}})
+
+	// Returned ratios must not modify the index
+	r, err := subject.FetchRatios(ctx, "bar")
+	require.NoError(t, err)
+	r[""].RecordPod(*fooPod)
+	assertIndexRatios(t, subject, "bar", map[string][2]string{"": {"0", "1337Gi"}})
+}
+
+func TestFetcher_WithIndex(t *testing.T) {
+	index := &Index{registration: syncedRegistration{}}
+	index.OnAdd(fooPod, true)
+	index.OnAdd(foo2Pod, true)
+
+	f := prepareTest(t, testCfg{
+		// Pods are only known to the index
+		initObjs: []client.Object{},
+	})
+	f.Index = index
+
+	r, err := f.FetchRatios(context.Background(), "foo")
+	require.NoError(t, err)
+	require.Len(t, r, 1)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].CPU, resource.BinarySI), "3")
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r[""].Memory, resource.BinarySI), "3Gi")
+
+	_, err = f.FetchRatios(context.Background(), "disabled-foo")
+	require.ErrorIs(t, err, ErrorDisabled)
+
+	// Falls back to listing pods if the index is not synced
+	f.Index = &Index{}
+	r, err = f.FetchRatios(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.Len(t, r, 0)
+}
+
+func assertIndexRatios(t *testing.T, index *Index, ns string, expected map[string][2]string) {
+	t.Helper()
+
+	r, err := index.FetchRatios(context.Background(), ns)
+	require.NoError(t, err)
+	require.Len(t, r, len(expected))
+	for nodeSel, e := range expected {
+		require.Contains(t, r, nodeSel)
+		assertResourceEqual(t, resource.NewDecimalQuantity(*r[nodeSel].CPU, resource.BinarySI), e[0])
+		assertResourceEqual(t, resource.NewDecimalQuantity(*r[nodeSel].Memory, resource.BinarySI), e[1])
+	}
+}
+
+type syncedRegistration struct{}
+
+func (syncedRegistration) HasSynced() bool { return true }
+
+func TestIndex_NodeClass(t *testing.T) {
+	subject := &Index{
+		NodeClassResolver: NodeClassResolver{NodeClassLabels: []string{"class"}},
+		registration:      syncedRegistration{},
+	}
+	nodes := indexNodeHandler{subject}
+	scheduled := tap(fooPod, func(p *corev1.Pod) *corev1.Pod {
+		p.Spec.NodeName = "node1"
+		return p
+	})
+
+	// Pods on unknown nodes are indexed without node class
+	subject.OnAdd(scheduled, true)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{"": {"3", "2Gi"}})
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"class": "flex", "other": "a"}}}
+	nodes.OnAdd(node, true)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{"class=flex": {"3", "2Gi"}})
+
+	// Changing the class of the node re-indexes the pods on the node
+	relabeled := tap(node, func(n *corev1.Node) *corev1.Node {
+		n.Labels = map[string]string{"class": "plus", "other": "b"}
+		return n
+	})
+	nodes.OnUpdate(node, relabeled)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{"class=plus": {"3", "2Gi"}})
+
+	// Pods added later use the known node class
+	subject.OnAdd(tap(foo2Pod, func(p *corev1.Pod) *corev1.Pod {
+		p.Spec.NodeName = "node1"
+		return p
+	}), true)
+	assertIndexRatios(t, subject, "foo", map[string][2]string{"class=plus": {"3", "3Gi"}})
+}
@@ -0,0 +1,101 @@
+package ratio
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeClassResolver resolves the effective node selector of a pod.
+// The zero value only uses `.spec.nodeSelector`.
+type NodeClassResolver struct {
+	// Client is used to look up the node a pod is scheduled on.
+	Client client.Reader
+	// NodeClassLabels are the node labels identifying the class of a node.
+	// If empty, only `.spec.nodeSelector` is used.
+	NodeClassLabels []string
+}
+
+// NodeSelector returns the effective node selector of the given pod spec.
+// The node class labels are taken from the node for scheduled pods and from the required node affinity for unscheduled pods.
+// They are added to `.spec.nodeSelector`.
+func (r NodeClassResolver) NodeSelector(ctx context.Context, spec corev1.PodSpec) (map[string]string, error) {
+	if len(r.NodeClassLabels) == 0 {
+		return spec.NodeSelector, nil
+	}
+
+	var classLabels map[string]string
+	if spec.NodeName != "" && r.Client != nil {
+		node := corev1.Node{}
+		err := r.Client.Get(ctx, client.ObjectKey{Name: spec.NodeName}, &node)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get node %q: %w", spec.NodeName, err)
+		}
+		classLabels = node.Labels
+	} else {
+		classLabels = requiredAffinityLabels(spec.Affinity)
+	}
+	return r.withClassLabels(spec, classLabels), nil
+}
+
+// NodeSelectorForNode returns the effective node selector of the given pod spec like NodeSelector,
+// but takes the labels of the node a scheduled pod runs on from the given nodeLabels instead of looking up the node.
+func (r NodeClassResolver) NodeSelectorForNode(spec corev1.PodSpec, nodeLabels map[string]string) map[string]string {
+	if len(r.NodeClassLabels) == 0 {
+		return spec.NodeSelector
+	}
+	if spec.NodeName == "" {
+		nodeLabels = requiredAffinityLabels(spec.Affinity)
+	}
+	return r.withClassLabels(spec, nodeLabels)
+}
+
+// ClassLabels returns the node class labels of the given node labels.
+func (r NodeClassResolver) ClassLabels(nodeLabels map[string]string) map[string]string {
+	classLabels := make(map[string]string, len(r.NodeClassLabels))
+	for _, k := range r.NodeClassLabels {
+		if v, ok := nodeLabels[k]; ok {
+			classLabels[k] = v
+		}
+	}
+	return classLabels
+}
+
+// withClassLabels returns the node selector of the pod spec with the node class labels taken from the given labels.
+func (r NodeClassResolver) withClassLabels(spec corev1.PodSpec, classLabels map[string]string) map[string]string {
+	sel := make(map[string]string, len(spec.NodeSelector)+len(r.NodeClassLabels))
+	for k, v := range spec.NodeSelector {
+		sel[k] = v
+	}
+	for k, v := range r.ClassLabels(classLabels) {
+		sel[k] = v
+	}
+	if len(sel) == 0 {
+		return spec.NodeSelector
+	}
+	return sel
+}
+
+// requiredAffinityLabels returns the labels a node must have to satisfy the required node affinity.
+// Only `In` expressions with a single value are considered and only if there is exactly one node selector term,
+// since multiple terms are ORed and do not select a single node class.
+func requiredAffinityLabels(affinity *corev1.Affinity) map[string]string {
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 {
+		return nil
+	}
+
+	lbls := make(map[string]string)
+	for _, expr := range terms[0].MatchExpressions {
+		if expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+			lbls[expr.Key] = expr.Values[0]
+		}
+	}
+	return lbls
+}
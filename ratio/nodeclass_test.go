@@ -0,0 +1,112 @@
+package ratio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeClassResolver_NodeSelector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-1",
+				Labels: map[string]string{
+					"class":                  "plus",
+					"kubernetes.io/hostname": "node-1",
+				},
+			},
+		}).
+		Build()
+
+	requiredAffinity := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+		return &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			},
+		}
+	}
+	classIn := func(values ...string) corev1.NodeSelectorTerm {
+		return corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "class", Operator: corev1.NodeSelectorOpIn, Values: values},
+			},
+		}
+	}
+
+	tcs := map[string]struct {
+		labels   []string
+		spec     corev1.PodSpec
+		expected map[string]string
+	}{
+		"no class labels": {
+			spec: corev1.PodSpec{
+				NodeName:     "node-1",
+				NodeSelector: map[string]string{"foo": "bar"},
+			},
+			expected: map[string]string{"foo": "bar"},
+		},
+		"scheduled pod": {
+			labels: []string{"class"},
+			spec: corev1.PodSpec{
+				NodeName:     "node-1",
+				NodeSelector: map[string]string{"foo": "bar"},
+			},
+			expected: map[string]string{"foo": "bar", "class": "plus"},
+		},
+		"scheduled pod on unknown node": {
+			labels:   []string{"class"},
+			spec:     corev1.PodSpec{NodeName: "node-2"},
+			expected: nil,
+		},
+		"unscheduled pod with affinity": {
+			labels: []string{"class"},
+			spec: corev1.PodSpec{
+				Affinity: requiredAffinity(classIn("flex")),
+			},
+			expected: map[string]string{"class": "flex"},
+		},
+		"unscheduled pod with ambiguous affinity": {
+			labels: []string{"class"},
+			spec: corev1.PodSpec{
+				Affinity: requiredAffinity(classIn("flex", "plus")),
+			},
+			expected: nil,
+		},
+		"unscheduled pod with multiple affinity terms": {
+			labels: []string{"class"},
+			spec: corev1.PodSpec{
+				Affinity: requiredAffinity(classIn("flex"), classIn("plus")),
+			},
+			expected: nil,
+		},
+		"unscheduled pod with affinity on other label": {
+			labels: []string{"other"},
+			spec: corev1.PodSpec{
+				NodeSelector: map[string]string{"foo": "bar"},
+				Affinity:     requiredAffinity(classIn("flex")),
+			},
+			expected: map[string]string{"foo": "bar"},
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			subject := NodeClassResolver{Client: c, NodeClassLabels: tc.labels}
+			sel, err := subject.NodeSelector(context.Background(), tc.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, sel)
+		})
+	}
+}
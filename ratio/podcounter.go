@@ -0,0 +1,66 @@
+package ratio
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodCounter decides which pods are counted in the ratio.
+type PodCounter struct {
+	// Phases are the pod phases counted in the ratio.
+	// Pods without a phase are considered Pending.
+	Phases []corev1.PodPhase
+	// CountUnschedulable counts Pending pods the scheduler failed to schedule.
+	// Only relevant if Phases contains Pending.
+	CountUnschedulable bool
+}
+
+// DefaultPodCounter returns a new PodCounter counting running pods and pending pods which are scheduled or schedulable.
+func DefaultPodCounter() PodCounter {
+	return PodCounter{
+		Phases: []corev1.PodPhase{corev1.PodRunning, corev1.PodPending},
+	}
+}
+
+// Counts returns true if the given pod is counted in the ratio.
+func (c PodCounter) Counts(pod *corev1.Pod) bool {
+	phase := pod.Status.Phase
+	if phase == "" {
+		phase = corev1.PodPending
+	}
+	if !slices.Contains(c.Phases, phase) {
+		return false
+	}
+	if phase == corev1.PodPending && !c.CountUnschedulable {
+		return !unschedulable(pod)
+	}
+	return true
+}
+
+// unschedulable returns true if the scheduler failed to schedule the pod.
+func unschedulable(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName != "" {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled {
+			return cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
+
+// NewPodExtractor returns a PodTemplateExtractor for pods counting only the pods selected by the given PodCounter.
+// The spec of pods not counted is still returned, so the node selector can be read.
+func NewPodExtractor(counter PodCounter) PodTemplateExtractor {
+	return NewPodTemplateExtractor(
+		func() *corev1.Pod { return &corev1.Pod{} },
+		func(pod *corev1.Pod) (int32, corev1.PodSpec, bool) {
+			if !counter.Counts(pod) {
+				return 0, pod.Spec, true
+			}
+			return 1, pod.Spec, true
+		},
+	)
+}
@@ -0,0 +1,77 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodCounter_Counts(t *testing.T) {
+	unschedulableCond := corev1.PodCondition{
+		Type:   corev1.PodScheduled,
+		Status: corev1.ConditionFalse,
+		Reason: corev1.PodReasonUnschedulable,
+	}
+	tcs := map[string]struct {
+		counter PodCounter
+		pod     corev1.Pod
+		counts  bool
+	}{
+		"running": {
+			counter: DefaultPodCounter(),
+			pod:     corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+			counts:  true,
+		},
+		"new pod without phase": {
+			counter: DefaultPodCounter(),
+			pod:     corev1.Pod{},
+			counts:  true,
+		},
+		"pending scheduled": {
+			counter: DefaultPodCounter(),
+			pod: corev1.Pod{
+				Spec:   corev1.PodSpec{NodeName: "node-1"},
+				Status: corev1.PodStatus{Phase: corev1.PodPending},
+			},
+			counts: true,
+		},
+		"pending unschedulable": {
+			counter: DefaultPodCounter(),
+			pod: corev1.Pod{
+				Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{unschedulableCond}},
+			},
+			counts: false,
+		},
+		"pending unschedulable counted": {
+			counter: PodCounter{Phases: []corev1.PodPhase{corev1.PodPending}, CountUnschedulable: true},
+			pod: corev1.Pod{
+				Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{unschedulableCond}},
+			},
+			counts: true,
+		},
+		"pending not counted": {
+			counter: PodCounter{Phases: []corev1.PodPhase{corev1.PodRunning}},
+			pod:     corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}},
+			counts:  false,
+		},
+		"succeeded": {
+			counter: DefaultPodCounter(),
+			pod:     corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+			counts:  false,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.counts, tc.counter.Counts(&tc.pod))
+
+			replicas, _, ok := NewPodExtractor(tc.counter).PodTemplate(&tc.pod)
+			assert.True(t, ok)
+			if tc.counts {
+				assert.Equal(t, int32(1), replicas)
+			} else {
+				assert.Equal(t, int32(0), replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package ratio
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oappsv1 "github.com/openshift/api/apps/v1"
+)
+
+// PodTemplateExtractor extracts the pod template of objects of a single kind.
+type PodTemplateExtractor interface {
+	// NewObject returns a new, empty object of the kind handled by the extractor.
+	// It is used to decode objects, for example from admission requests.
+	NewObject() client.Object
+	// PodTemplate returns the number of pods the object creates and the pod spec of those pods.
+	// ok is false if the object has no pod template or is of the wrong type.
+	PodTemplate(obj client.Object) (replicas int32, spec corev1.PodSpec, ok bool)
+}
+
+// PodTemplateExtractors is a registry of PodTemplateExtractors by GroupKind.
+type PodTemplateExtractors map[schema.GroupKind]PodTemplateExtractor
+
+// Register registers the extractor for the given GroupKind.
+// An already registered extractor for the same GroupKind is replaced.
+func (e PodTemplateExtractors) Register(gk schema.GroupKind, extractor PodTemplateExtractor) {
+	e[gk] = extractor
+}
+
+// Extractor returns the extractor for the given GroupKind.
+// ok is false if there is no extractor registered for the GroupKind.
+func (e PodTemplateExtractors) Extractor(gk schema.GroupKind) (extractor PodTemplateExtractor, ok bool) {
+	extractor, ok = e[gk]
+	return extractor, ok
+}
+
+// NewPodTemplateExtractor returns a PodTemplateExtractor for the type T.
+// newObj must return a new, empty object of type T.
+func NewPodTemplateExtractor[T client.Object](newObj func() T, extract func(T) (int32, corev1.PodSpec, bool)) PodTemplateExtractor {
+	return podTemplateExtractor[T]{
+		newObj:  newObj,
+		extract: extract,
+	}
+}
+
+type podTemplateExtractor[T client.Object] struct {
+	newObj  func() T
+	extract func(T) (int32, corev1.PodSpec, bool)
+}
+
+func (p podTemplateExtractor[T]) NewObject() client.Object {
+	return p.newObj()
+}
+
+func (p podTemplateExtractor[T]) PodTemplate(obj client.Object) (int32, corev1.PodSpec, bool) {
+	o, ok := obj.(T)
+	if !ok {
+		return 0, corev1.PodSpec{}, false
+	}
+	return p.extract(o)
+}
+
+// DefaultPodTemplateExtractors returns a new registry with extractors for all built-in workload kinds and OpenShift DeploymentConfigs.
+// The returned registry can be modified without affecting other callers.
+func DefaultPodTemplateExtractors() PodTemplateExtractors {
+	return PodTemplateExtractors{
+		corev1.SchemeGroupVersion.WithKind("Pod").GroupKind(): NewPodExtractor(DefaultPodCounter()),
+		appsv1.SchemeGroupVersion.WithKind("Deployment").GroupKind(): NewPodTemplateExtractor(
+			func() *appsv1.Deployment { return &appsv1.Deployment{} },
+			func(deploy *appsv1.Deployment) (int32, corev1.PodSpec, bool) {
+				return replicasOrDefault(deploy.Spec.Replicas), deploy.Spec.Template.Spec, true
+			},
+		),
+		appsv1.SchemeGroupVersion.WithKind("StatefulSet").GroupKind(): NewPodTemplateExtractor(
+			func() *appsv1.StatefulSet { return &appsv1.StatefulSet{} },
+			func(sts *appsv1.StatefulSet) (int32, corev1.PodSpec, bool) {
+				return replicasOrDefault(sts.Spec.Replicas), sts.Spec.Template.Spec, true
+			},
+		),
+		appsv1.SchemeGroupVersion.WithKind("ReplicaSet").GroupKind(): NewPodTemplateExtractor(
+			func() *appsv1.ReplicaSet { return &appsv1.ReplicaSet{} },
+			func(rs *appsv1.ReplicaSet) (int32, corev1.PodSpec, bool) {
+				return replicasOrDefault(rs.Spec.Replicas), rs.Spec.Template.Spec, true
+			},
+		),
+		appsv1.SchemeGroupVersion.WithKind("DaemonSet").GroupKind(): NewPodTemplateExtractor(
+			func() *appsv1.DaemonSet { return &appsv1.DaemonSet{} },
+			func(ds *appsv1.DaemonSet) (int32, corev1.PodSpec, bool) {
+				// The number of nodes is only known after the DaemonSet controller has seen the object.
+				// We assume a single pod until then.
+				return max(ds.Status.DesiredNumberScheduled, 1), ds.Spec.Template.Spec, true
+			},
+		),
+		batchv1.SchemeGroupVersion.WithKind("Job").GroupKind(): NewPodTemplateExtractor(
+			func() *batchv1.Job { return &batchv1.Job{} },
+			func(job *batchv1.Job) (int32, corev1.PodSpec, bool) {
+				return jobParallelism(job.Spec), job.Spec.Template.Spec, true
+			},
+		),
+		batchv1.SchemeGroupVersion.WithKind("CronJob").GroupKind(): NewPodTemplateExtractor(
+			func() *batchv1.CronJob { return &batchv1.CronJob{} },
+			func(cj *batchv1.CronJob) (int32, corev1.PodSpec, bool) {
+				return jobParallelism(cj.Spec.JobTemplate.Spec), cj.Spec.JobTemplate.Spec.Template.Spec, true
+			},
+		),
+		oappsv1.SchemeGroupVersion.WithKind("DeploymentConfig").GroupKind(): NewPodTemplateExtractor(
+			func() *oappsv1.DeploymentConfig { return &oappsv1.DeploymentConfig{} },
+			func(dc *oappsv1.DeploymentConfig) (int32, corev1.PodSpec, bool) {
+				if dc.Spec.Template == nil {
+					return 0, corev1.PodSpec{}, false
+				}
+				return dc.Spec.Replicas, dc.Spec.Template.Spec, true
+			},
+		),
+	}
+}
+
+// replicasOrDefault returns the given replicas or 1 if unset.
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// jobParallelism returns the maximum number of pods the job runs in parallel.
+func jobParallelism(spec batchv1.JobSpec) int32 {
+	parallelism := replicasOrDefault(spec.Parallelism)
+	if spec.Completions != nil && *spec.Completions < parallelism {
+		return *spec.Completions
+	}
+	return parallelism
+}
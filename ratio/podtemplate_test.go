@@ -0,0 +1,177 @@
+package ratio
+
+import (
+	"testing"
+
+	oappsv1 "github.com/openshift/api/apps/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDefaultPodTemplateExtractors(t *testing.T) {
+	spec := corev1.PodSpec{
+		NodeSelector: map[string]string{"class": "foo"},
+		Containers:   newTestContainers([]containerResources{{cpu: "1", memory: "1Gi"}}),
+	}
+	template := corev1.PodTemplateSpec{Spec: spec}
+
+	tcs := map[string]struct {
+		gk       schema.GroupKind
+		obj      client.Object
+		replicas int32
+		noTpl    bool
+	}{
+		"running pod": {
+			gk: schema.GroupKind{Kind: "Pod"},
+			obj: &corev1.Pod{
+				Spec:   spec,
+				Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			},
+			replicas: 1,
+		},
+		"completed pod": {
+			gk: schema.GroupKind{Kind: "Pod"},
+			obj: &corev1.Pod{
+				Spec:   spec,
+				Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+			},
+			replicas: 0,
+		},
+		"pending pod": {
+			gk: schema.GroupKind{Kind: "Pod"},
+			obj: &corev1.Pod{
+				Spec:   spec,
+				Status: corev1.PodStatus{Phase: corev1.PodPending},
+			},
+			replicas: 1,
+		},
+		"deployment": {
+			gk: schema.GroupKind{Group: "apps", Kind: "Deployment"},
+			obj: &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Replicas: ptr.To(int32(3)), Template: template},
+			},
+			replicas: 3,
+		},
+		"deployment default replicas": {
+			gk: schema.GroupKind{Group: "apps", Kind: "Deployment"},
+			obj: &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{Template: template},
+			},
+			replicas: 1,
+		},
+		"statefulset": {
+			gk: schema.GroupKind{Group: "apps", Kind: "StatefulSet"},
+			obj: &appsv1.StatefulSet{
+				Spec: appsv1.StatefulSetSpec{Replicas: ptr.To(int32(2)), Template: template},
+			},
+			replicas: 2,
+		},
+		"replicaset": {
+			gk: schema.GroupKind{Group: "apps", Kind: "ReplicaSet"},
+			obj: &appsv1.ReplicaSet{
+				Spec: appsv1.ReplicaSetSpec{Replicas: ptr.To(int32(4)), Template: template},
+			},
+			replicas: 4,
+		},
+		"new daemonset": {
+			gk: schema.GroupKind{Group: "apps", Kind: "DaemonSet"},
+			obj: &appsv1.DaemonSet{
+				Spec: appsv1.DaemonSetSpec{Template: template},
+			},
+			replicas: 1,
+		},
+		"scheduled daemonset": {
+			gk: schema.GroupKind{Group: "apps", Kind: "DaemonSet"},
+			obj: &appsv1.DaemonSet{
+				Spec:   appsv1.DaemonSetSpec{Template: template},
+				Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 5},
+			},
+			replicas: 5,
+		},
+		"job": {
+			gk: schema.GroupKind{Group: "batch", Kind: "Job"},
+			obj: &batchv1.Job{
+				Spec: batchv1.JobSpec{Parallelism: ptr.To(int32(3)), Template: template},
+			},
+			replicas: 3,
+		},
+		"job with less completions than parallelism": {
+			gk: schema.GroupKind{Group: "batch", Kind: "Job"},
+			obj: &batchv1.Job{
+				Spec: batchv1.JobSpec{Parallelism: ptr.To(int32(3)), Completions: ptr.To(int32(2)), Template: template},
+			},
+			replicas: 2,
+		},
+		"cronjob": {
+			gk: schema.GroupKind{Group: "batch", Kind: "CronJob"},
+			obj: &batchv1.CronJob{
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{Template: template},
+					},
+				},
+			},
+			replicas: 1,
+		},
+		"deploymentconfig": {
+			gk: schema.GroupKind{Group: "apps.openshift.io", Kind: "DeploymentConfig"},
+			obj: &oappsv1.DeploymentConfig{
+				Spec: oappsv1.DeploymentConfigSpec{Replicas: 6, Template: &template},
+			},
+			replicas: 6,
+		},
+		"deploymentconfig without template": {
+			gk: schema.GroupKind{Group: "apps.openshift.io", Kind: "DeploymentConfig"},
+			obj: &oappsv1.DeploymentConfig{
+				Spec: oappsv1.DeploymentConfigSpec{Replicas: 6},
+			},
+			noTpl: true,
+		},
+		"wrong type": {
+			gk:    schema.GroupKind{Group: "apps", Kind: "Deployment"},
+			obj:   &appsv1.StatefulSet{},
+			noTpl: true,
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			extractor, ok := DefaultPodTemplateExtractors().Extractor(tc.gk)
+			require.True(t, ok, "extractor should be registered")
+
+			replicas, podSpec, ok := extractor.PodTemplate(tc.obj)
+			if tc.noTpl {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.IsType(t, tc.obj, extractor.NewObject())
+			assert.Equal(t, tc.replicas, replicas)
+			assert.Equal(t, spec, podSpec)
+		})
+	}
+}
+
+func TestPodTemplateExtractors_Register(t *testing.T) {
+	gk := schema.GroupKind{Group: "example.com", Kind: "Workload"}
+	subject := PodTemplateExtractors{}
+
+	_, ok := subject.Extractor(gk)
+	assert.False(t, ok)
+
+	subject.Register(gk, NewPodTemplateExtractor(
+		func() *corev1.Pod { return &corev1.Pod{} },
+		func(pod *corev1.Pod) (int32, corev1.PodSpec, bool) { return 7, pod.Spec, true },
+	))
+	extractor, ok := subject.Extractor(gk)
+	require.True(t, ok)
+	replicas, _, ok := extractor.PodTemplate(&corev1.Pod{})
+	assert.True(t, ok)
+	assert.Equal(t, int32(7), replicas)
+}
@@ -14,6 +14,9 @@ import (
 type Ratio struct {
 	CPU    *inf.Dec
 	Memory *inf.Dec
+
+	// Organization is set if the ratio is aggregated over all namespaces of the organization.
+	Organization string
 }
 
 // NewRatio returns an initialized Ratio
@@ -24,13 +27,11 @@ func NewRatio() *Ratio {
 	}
 }
 
-func (r *Ratio) recordReplicatedPodSpec(replicas int32, spec corev1.PodSpec) *Ratio {
-	cpu := inf.NewDec(0, 0)
-	mem := inf.NewDec(0, 0)
-	for _, c := range spec.Containers {
-		mem.Add(mem, c.Resources.Requests.Memory().AsDec())
-		cpu.Add(cpu, c.Resources.Requests.Cpu().AsDec())
-	}
+// RecordPodTemplate adds the effective requests of the given pod spec times the number of replicas to the ratio.
+func (r *Ratio) RecordPodTemplate(replicas int32, spec corev1.PodSpec) *Ratio {
+	reqs := PodRequests(spec)
+	cpu := inf.NewDec(0, 0).Set(reqs.Cpu().AsDec())
+	mem := inf.NewDec(0, 0).Set(reqs.Memory().AsDec())
 	rep := inf.NewDec(int64(replicas), 0)
 
 	r.CPU.Add(r.CPU, cpu.Mul(cpu, rep))
@@ -38,12 +39,101 @@ func (r *Ratio) recordReplicatedPodSpec(replicas int32, spec corev1.PodSpec) *Ra
 	return r
 }
 
+// RemovePodTemplate subtracts the effective requests of the given pod spec times the number of replicas from the ratio.
+// The recorded requests never drop below zero.
+func (r *Ratio) RemovePodTemplate(replicas int32, spec corev1.PodSpec) *Ratio {
+	reqs := PodRequests(spec)
+	cpu := inf.NewDec(0, 0).Set(reqs.Cpu().AsDec())
+	mem := inf.NewDec(0, 0).Set(reqs.Memory().AsDec())
+	rep := inf.NewDec(int64(replicas), 0)
+
+	zero := inf.NewDec(0, 0)
+	r.CPU.Sub(r.CPU, cpu.Mul(cpu, rep))
+	if r.CPU.Cmp(zero) < 0 {
+		r.CPU.Set(zero)
+	}
+	r.Memory.Sub(r.Memory, mem.Mul(mem, rep))
+	if r.Memory.Cmp(zero) < 0 {
+		r.Memory.Set(zero)
+	}
+	return r
+}
+
+// PodRequests returns the effective resource requests of a pod the same way the scheduler calculates them.
+// The effective request is the maximum of the sum of all regular containers and restartable init containers (sidecars),
+// and the highest request of any init container plus the sidecars started before it.
+// The pod overhead is added to the result.
+// See https://kubernetes.io/docs/concepts/workloads/pods/sidecar-containers/#resource-sharing-within-containers
+func PodRequests(spec corev1.PodSpec) corev1.ResourceList {
+	return podResources(spec, func(r corev1.ResourceRequirements) corev1.ResourceList { return r.Requests })
+}
+
+// PodLimits returns the effective resource limits of a pod.
+// They are calculated the same way as the effective requests, see PodRequests.
+// Containers without a limit for a resource are ignored, so the result is only meaningful if all containers have limits.
+func PodLimits(spec corev1.PodSpec) corev1.ResourceList {
+	return podResources(spec, func(r corev1.ResourceRequirements) corev1.ResourceList { return r.Limits })
+}
+
+func podResources(spec corev1.PodSpec, get func(corev1.ResourceRequirements) corev1.ResourceList) corev1.ResourceList {
+	reqs := corev1.ResourceList{}
+	for _, c := range spec.Containers {
+		addResourceList(reqs, get(c.Resources))
+	}
+
+	sidecarReqs := corev1.ResourceList{}
+	initReqs := corev1.ResourceList{}
+	for _, c := range spec.InitContainers {
+		if isSidecar(c) {
+			// Sidecars keep running, their requests are added to all following init containers and the regular containers.
+			addResourceList(sidecarReqs, get(c.Resources))
+			maxResourceList(initReqs, sidecarReqs)
+			continue
+		}
+		tmp := sidecarReqs.DeepCopy()
+		addResourceList(tmp, get(c.Resources))
+		maxResourceList(initReqs, tmp)
+	}
+
+	addResourceList(reqs, sidecarReqs)
+	maxResourceList(reqs, initReqs)
+	addResourceList(reqs, spec.Overhead)
+	return reqs
+}
+
+// isSidecar returns true if the given init container is a restartable init container (sidecar).
+func isSidecar(c corev1.Container) bool {
+	return c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// addResourceList adds the resources in new to list.
+func addResourceList(list, new corev1.ResourceList) {
+	for name, quantity := range new {
+		if value, ok := list[name]; ok {
+			value.Add(quantity)
+			list[name] = value
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// maxResourceList sets list to the greater of list and new for every resource in new.
+func maxResourceList(list, new corev1.ResourceList) {
+	for name, quantity := range new {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
 // RecordPod collects all requests in the given Pod(s), and adds it to the ratio
-// The function only considers pods in phase `Running`.
+// The function only considers pods counted by the DefaultPodCounter.
 func (r *Ratio) RecordPod(pods ...corev1.Pod) *Ratio {
+	counter := DefaultPodCounter()
 	for _, pod := range pods {
-		if pod.Status.Phase == corev1.PodRunning {
-			r.recordReplicatedPodSpec(1, pod.Spec)
+		if counter.Counts(&pod) {
+			r.RecordPodTemplate(1, pod.Spec)
 		}
 	}
 	return r
@@ -52,11 +142,7 @@ func (r *Ratio) RecordPod(pods ...corev1.Pod) *Ratio {
 // RecordDeployment collects all requests in the given deployment(s) and adds it to the ratio
 func (r *Ratio) RecordDeployment(deps ...appsv1.Deployment) *Ratio {
 	for _, dep := range deps {
-		replicas := int32(1)
-		if dep.Spec.Replicas != nil {
-			replicas = *dep.Spec.Replicas
-		}
-		r.recordReplicatedPodSpec(replicas, dep.Spec.Template.Spec)
+		r.RecordPodTemplate(replicasOrDefault(dep.Spec.Replicas), dep.Spec.Template.Spec)
 	}
 	return r
 }
@@ -64,11 +150,7 @@ func (r *Ratio) RecordDeployment(deps ...appsv1.Deployment) *Ratio {
 // RecordStatefulSet collects all requests in the given StatefulSet(s) and adds it to the ratio
 func (r *Ratio) RecordStatefulSet(stss ...appsv1.StatefulSet) *Ratio {
 	for _, sts := range stss {
-		replicas := int32(1)
-		if sts.Spec.Replicas != nil {
-			replicas = *sts.Spec.Replicas
-		}
-		r.recordReplicatedPodSpec(replicas, sts.Spec.Template.Spec)
+		r.RecordPodTemplate(replicasOrDefault(sts.Spec.Replicas), sts.Spec.Template.Spec)
 	}
 	return r
 }
@@ -103,6 +185,40 @@ func (r Ratio) Below(limit resource.Quantity, threshold *inf.Dec) bool {
 	return r.Ratio() != nil && r.Ratio().AsDec().Cmp(inf.NewDec(0, 0).Mul(limit.AsDec(), threshold)) < 0
 }
 
+// ExcessCPU returns the CPU requests exceeding the fair use ratio of the given limit.
+// Those are the CPU requests which would have to be removed to reach the limit and which are billed in excess.
+// ExcessCPU rounds up to the nearest millicore.
+// Returns zero if the ratio is not below the limit.
+func (r Ratio) ExcessCPU(limit resource.Quantity) *resource.Quantity {
+	zero := inf.NewDec(0, 0)
+	if limit.AsDec().Cmp(zero) <= 0 {
+		return resource.NewMilliQuantity(0, resource.DecimalSI)
+	}
+
+	fairCPU := inf.NewDec(0, 0).QuoRound(r.Memory, limit.AsDec(), 3, inf.RoundDown)
+	excess := inf.NewDec(0, 0).Sub(r.CPU, fairCPU)
+	if excess.Cmp(zero) < 0 {
+		excess.Set(zero)
+	}
+	excess.Round(excess, 3, inf.RoundCeil)
+	return resource.NewDecimalQuantity(*excess, resource.DecimalSI)
+}
+
+// MissingMemory returns the memory requests which would have to be added to reach the given limit.
+// MissingMemory rounds up to the nearest MiB.
+// Returns zero if the ratio is not below the limit.
+func (r Ratio) MissingMemory(limit resource.Quantity) *resource.Quantity {
+	fairMemory := inf.NewDec(0, 0).Mul(r.CPU, limit.AsDec())
+	missing := fairMemory.Sub(fairMemory, r.Memory)
+	if missing.Cmp(inf.NewDec(0, 0)) < 0 {
+		return resource.NewQuantity(0, resource.BinarySI)
+	}
+	mib := inf.NewDec(1024*1024, 0)
+	missing.QuoRound(missing, mib, 0, inf.RoundUp)
+	missing.Mul(missing, mib)
+	return resource.NewDecimalQuantity(*missing, resource.BinarySI)
+}
+
 // String implements Stringer to print ratio
 func (r Ratio) String() string {
 	return r.Ratio().String()
@@ -115,11 +231,18 @@ func (r Ratio) Warn(limit *resource.Quantity, nodeSelector string) string {
 	if nodeSelector != "" {
 		w = fmt.Sprintf("%s for node type %q", w, nodeSelector)
 	}
-	w = fmt.Sprintf("%s in this namespace is below the fair use ratio", w)
+	if r.Organization != "" {
+		w = fmt.Sprintf("%s in organization %q is below the fair use ratio", w, r.Organization)
+	} else {
+		w = fmt.Sprintf("%s in this namespace is below the fair use ratio", w)
+	}
 	if limit != nil {
 		w = fmt.Sprintf("%s of %s/core", w, limit)
 	}
 	w = fmt.Sprintf("%s. APPUiO Cloud bills CPU requests which exceed the fair use ratio.", w)
+	if limit != nil {
+		w = fmt.Sprintf("%s Reduce the CPU requests by %s or increase the memory requests by %s to reach the fair use ratio.", w, r.ExcessCPU(*limit), r.MissingMemory(*limit))
+	}
 	w = fmt.Sprintf("%s See https://vs.hn/appuio-cloud-cpu-requests for instructions to adjust the requests.", w)
 	return w
 }
@@ -115,6 +115,82 @@ func TestRatio_Record(t *testing.T) {
 			cpuSum:    "601m",
 			memorySum: "5Gi",
 		},
+		"init containers": {
+			pods: []podResource{
+				{
+					containers: []containerResources{
+						{
+							cpu:    "500m",
+							memory: "1Gi",
+						},
+					},
+					initContainers: []containerResources{
+						{
+							cpu:    "2",
+							memory: "512Mi",
+						},
+						{
+							cpu:    "100m",
+							memory: "3Gi",
+						},
+					},
+					phase: corev1.PodRunning,
+				},
+			},
+			cpuSum:    "2",
+			memorySum: "3Gi",
+		},
+		"sidecars": {
+			pods: []podResource{
+				{
+					containers: []containerResources{
+						{
+							cpu:    "500m",
+							memory: "1Gi",
+						},
+					},
+					initContainers: []containerResources{
+						{
+							cpu:     "100m",
+							memory:  "128Mi",
+							sidecar: true,
+						},
+						{
+							cpu:    "1",
+							memory: "512Mi",
+						},
+						{
+							cpu:     "200m",
+							memory:  "256Mi",
+							sidecar: true,
+						},
+					},
+					phase: corev1.PodRunning,
+				},
+			},
+			// max(100m+1, 100m+200m+500m) = 1100m, max(128Mi+512Mi, 128Mi+256Mi+1Gi) = 1408Mi
+			cpuSum:    "1100m",
+			memorySum: "1408Mi",
+		},
+		"overhead": {
+			pods: []podResource{
+				{
+					containers: []containerResources{
+						{
+							cpu:    "500m",
+							memory: "1Gi",
+						},
+					},
+					overhead: containerResources{
+						cpu:    "250m",
+						memory: "120Mi",
+					},
+					phase: corev1.PodRunning,
+				},
+			},
+			cpuSum:    "750m",
+			memorySum: "1144Mi",
+		},
 		"deployments": {
 			deployments: []deployResource{
 				{
@@ -143,6 +219,28 @@ func TestRatio_Record(t *testing.T) {
 			cpuSum:    "2904m",
 			memorySum: "26Gi",
 		},
+		"deployments with init containers": {
+			deployments: []deployResource{
+				{
+					replicas: 3,
+					containers: []containerResources{
+						{
+							cpu:    "500m",
+							memory: "1Gi",
+						},
+					},
+					initContainers: []containerResources{
+						{
+							cpu:     "100m",
+							memory:  "1Gi",
+							sidecar: true,
+						},
+					},
+				},
+			},
+			cpuSum:    "1800m",
+			memorySum: "6Gi",
+		},
 		"statefulsets": {
 			statefulsets: []deployResource{
 				{
@@ -184,6 +282,10 @@ func TestRatio_Record(t *testing.T) {
 					},
 				}
 				pod.Spec.Containers = newTestContainers(pr.containers)
+				pod.Spec.InitContainers = newTestContainers(pr.initContainers)
+				if pr.overhead != (containerResources{}) {
+					pod.Spec.Overhead = newTestContainers([]containerResources{pr.overhead})[0].Resources.Requests
+				}
 				r.RecordPod(pod)
 			}
 
@@ -191,6 +293,7 @@ func TestRatio_Record(t *testing.T) {
 				deploy := appsv1.Deployment{}
 				deploy.Spec.Replicas = &tc.deployments[i].replicas
 				deploy.Spec.Template.Spec.Containers = newTestContainers(tc.deployments[i].containers)
+				deploy.Spec.Template.Spec.InitContainers = newTestContainers(tc.deployments[i].initContainers)
 				r.RecordDeployment(deploy)
 			}
 			for i := range tc.statefulsets {
@@ -206,6 +309,21 @@ func TestRatio_Record(t *testing.T) {
 	}
 }
 
+func TestRatio_RemovePodTemplate(t *testing.T) {
+	spec := corev1.PodSpec{
+		Containers: newTestContainers([]containerResources{{cpu: "1", memory: "1Gi"}}),
+	}
+
+	r := NewRatio().RecordPodTemplate(3, spec)
+	r.RemovePodTemplate(1, spec)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r.CPU, resource.BinarySI), "2")
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r.Memory, resource.BinarySI), "2Gi")
+
+	r.RemovePodTemplate(5, spec)
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r.CPU, resource.BinarySI), "0")
+	assertResourceEqual(t, resource.NewDecimalQuantity(*r.Memory, resource.BinarySI), "0")
+}
+
 func TestRatio_ratio(t *testing.T) {
 	tcs := []struct {
 		cpu    string
@@ -305,6 +423,63 @@ func TestRatio_Warn(t *testing.T) {
 	assert.Contains(t, r.Warn(&lim, ""), "1Mi")
 
 	assert.Contains(t, r.Warn(&lim, "class=x"), "class=x")
+
+	lim = resource.MustParse("4Gi")
+	assert.Contains(t, r.Warn(&lim, ""), "Reduce the CPU requests by 750m or increase the memory requests by 3Gi")
+	assert.NotContains(t, r.Warn(&lim, ""), "billed in excess", "should mention the excess CPU only once")
+}
+
+func TestRatio_Suggestions(t *testing.T) {
+	tcs := map[string]struct {
+		cpu           string
+		memory        string
+		limit         string
+		excessCPU     string
+		missingMemory string
+	}{
+		"below limit": {
+			cpu:           "2",
+			memory:        "4Gi",
+			limit:         "4Gi",
+			excessCPU:     "1",
+			missingMemory: "4Gi",
+		},
+		"rounds up": {
+			cpu:           "1",
+			memory:        "1000Mi",
+			limit:         "3Gi",
+			excessCPU:     "675m",
+			missingMemory: "2072Mi",
+		},
+		"above limit": {
+			cpu:           "1",
+			memory:        "8Gi",
+			limit:         "4Gi",
+			excessCPU:     "0",
+			missingMemory: "0",
+		},
+		"zero limit": {
+			cpu:           "1",
+			memory:        "1Gi",
+			limit:         "0",
+			excessCPU:     "0",
+			missingMemory: "0",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			cpu := resource.MustParse(tc.cpu)
+			memory := resource.MustParse(tc.memory)
+			r := Ratio{
+				CPU:    cpu.AsDec(),
+				Memory: memory.AsDec(),
+			}
+			limit := resource.MustParse(tc.limit)
+
+			assertResourceEqual(t, r.ExcessCPU(limit), tc.excessCPU)
+			assertResourceEqual(t, r.MissingMemory(limit), tc.missingMemory)
+		})
+	}
 }
 
 func FuzzRatio(f *testing.F) {
@@ -34,6 +34,10 @@ func newTestContainers(res []containerResources) []corev1.Container {
 		if cr.memory != "" {
 			container.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(cr.memory)
 		}
+		if cr.sidecar {
+			always := corev1.ContainerRestartPolicyAlways
+			container.RestartPolicy = &always
+		}
 		containers = append(containers, container)
 	}
 	return containers
@@ -95,14 +99,19 @@ func podFromResources(name, namespace string, res podResource) *corev1.Pod {
 }
 
 type deployResource struct {
-	containers []containerResources
-	replicas   int32
+	containers     []containerResources
+	initContainers []containerResources
+	replicas       int32
 }
 type podResource struct {
-	containers []containerResources
-	phase      corev1.PodPhase
+	containers     []containerResources
+	initContainers []containerResources
+	overhead       containerResources
+	phase          corev1.PodPhase
 }
 type containerResources struct {
 	cpu    string
 	memory string
+	// sidecar marks an init container as restartable
+	sidecar bool
 }
@@ -0,0 +1,134 @@
+package ratio
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WarningData is the data available in warning templates.
+type WarningData struct {
+	// Ratio is the current memory to CPU ratio.
+	Ratio string
+	// Limit is the fair use ratio. Empty if there is no limit.
+	Limit string
+	// NodeSelector is the node selector the ratio applies to.
+	NodeSelector string
+	// Namespace is the name of the namespace.
+	Namespace string
+	// Organization is the organization the namespace belongs to.
+	Organization string
+	// ExcessCPU is the CPU request exceeding the fair use ratio. Empty if there is no limit.
+	ExcessCPU string
+	// MissingMemory is the memory request missing to reach the fair use ratio. Empty if there is no limit.
+	MissingMemory string
+}
+
+// Warner renders warnings explaining that a ratio is not considered fair use.
+// A nil Warner or a Warner without templates renders Ratio.Warn.
+type Warner struct {
+	// OrganizationLabel is the namespace label identifying the organization of a namespace.
+	OrganizationLabel string
+	// LanguageAnnotation is the annotation on namespaces or users selecting the language of the warning.
+	LanguageAnnotation string
+
+	template  *template.Template
+	languages map[string]*template.Template
+}
+
+// NewWarner parses the given default template and the per-language templates.
+// Templates are Go text/templates rendered with WarningData.
+// Newlines and repeated whitespace are collapsed when rendering, since Kubernetes drops warnings containing newlines.
+// If the default template is empty, Ratio.Warn is used for languages without a template.
+func NewWarner(defaultTemplate string, languageTemplates map[string]string) (*Warner, error) {
+	w := &Warner{
+		languages: make(map[string]*template.Template, len(languageTemplates)),
+	}
+	if defaultTemplate != "" {
+		t, err := parseWarningTemplate("default", defaultTemplate)
+		if err != nil {
+			return nil, err
+		}
+		w.template = t
+	}
+	for lang, tmpl := range languageTemplates {
+		t, err := parseWarningTemplate(lang, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		w.languages[lang] = t
+	}
+	return w, nil
+}
+
+func parseWarningTemplate(name, tmpl string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse warning template %q: %w", name, err)
+	}
+	// Catch references to unknown fields early
+	if err := t.Execute(&bytes.Buffer{}, WarningData{}); err != nil {
+		return nil, fmt.Errorf("failed to render warning template %q: %w", name, err)
+	}
+	return t, nil
+}
+
+// Language returns the language selected by the first of the given objects having the LanguageAnnotation.
+// Returns an empty string if none of the objects select a language.
+func (w *Warner) Language(objs ...metav1.Object) string {
+	if w == nil || w.LanguageAnnotation == "" {
+		return ""
+	}
+	for _, obj := range objs {
+		if obj == nil {
+			continue
+		}
+		if lang := obj.GetAnnotations()[w.LanguageAnnotation]; lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Warn returns a warning string for the given ratio in the given namespace using the template of the given language.
+// The default template is used if there is no template for the language.
+func (w *Warner) Warn(r Ratio, limit *resource.Quantity, nodeSelector string, ns corev1.Namespace, language string) string {
+	if w == nil {
+		return r.Warn(limit, nodeSelector)
+	}
+	t, ok := w.languages[language]
+	if !ok {
+		t = w.template
+	}
+	if t == nil {
+		return r.Warn(limit, nodeSelector)
+	}
+
+	data := WarningData{
+		Ratio:        r.String(),
+		NodeSelector: nodeSelector,
+		Namespace:    ns.Name,
+		Organization: r.Organization,
+	}
+	if data.Organization == "" && w.OrganizationLabel != "" {
+		data.Organization = ns.Labels[w.OrganizationLabel]
+	}
+	if limit != nil {
+		data.Limit = limit.String()
+		data.ExcessCPU = r.ExcessCPU(*limit).String()
+		data.MissingMemory = r.MissingMemory(*limit).String()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		// Templates are checked when parsing, this should not happen.
+		return r.Warn(limit, nodeSelector)
+	}
+	// WARNING(glrf) Warnings MUST NOT contain newlines. K8s will simply drop your warning if you add newlines.
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
@@ -0,0 +1,77 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWarner_Warn(t *testing.T) {
+	cpu := resource.MustParse("2")
+	memory := resource.MustParse("4Gi")
+	r := Ratio{
+		CPU:    cpu.AsDec(),
+		Memory: memory.AsDec(),
+	}
+	limit := resource.MustParse("4Gi")
+	ns := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "foo",
+			Labels: map[string]string{"org": "acme"},
+		},
+	}
+
+	subject, err := NewWarner(`Ratio {{ .Ratio }} of {{ .Namespace }}
+		({{ .Organization }}) on {{ .NodeSelector }} is below {{ .Limit }}.
+		Drop {{ .ExcessCPU }} or add {{ .MissingMemory }}.`, map[string]string{
+		"de": `Verhältnis {{ .Ratio }} unter {{ .Limit }}.`,
+	})
+	require.NoError(t, err)
+	subject.OrganizationLabel = "org"
+
+	assert.Equal(t,
+		"Ratio 2Gi of foo (acme) on class=x is below 4Gi. Drop 1 or add 4Gi.",
+		subject.Warn(r, &limit, "class=x", ns, ""))
+	assert.Equal(t,
+		"Verhältnis 2Gi unter 4Gi.",
+		subject.Warn(r, &limit, "class=x", ns, "de"))
+	assert.Equal(t,
+		"Ratio 2Gi of foo (acme) on class=x is below 4Gi. Drop 1 or add 4Gi.",
+		subject.Warn(r, &limit, "class=x", ns, "fr"), "should fall back to default template for unknown languages")
+
+	orgRatio := r
+	orgRatio.Organization = "aggregated"
+	assert.Contains(t, subject.Warn(orgRatio, &limit, "", ns, ""), "(aggregated)")
+
+	var nilWarner *Warner
+	assert.Equal(t, r.Warn(&limit, ""), nilWarner.Warn(r, &limit, "", ns, ""))
+
+	noDefault, err := NewWarner("", map[string]string{"de": "Verhältnis {{ .Ratio }}"})
+	require.NoError(t, err)
+	assert.Equal(t, r.Warn(&limit, ""), noDefault.Warn(r, &limit, "", ns, "en"))
+	assert.Equal(t, "Verhältnis 2Gi", noDefault.Warn(r, &limit, "", ns, "de"))
+}
+
+func TestNewWarner_Invalid(t *testing.T) {
+	_, err := NewWarner("{{ .Ratio ", nil)
+	assert.Error(t, err)
+	_, err = NewWarner("", map[string]string{"de": "{{ .Unknown }}"})
+	assert.Error(t, err)
+}
+
+func TestWarner_Language(t *testing.T) {
+	subject := &Warner{LanguageAnnotation: "lang"}
+
+	user := &metav1.ObjectMeta{Annotations: map[string]string{"lang": "de"}}
+	ns := &metav1.ObjectMeta{Annotations: map[string]string{"lang": "fr"}}
+	assert.Equal(t, "de", subject.Language(user, ns))
+	assert.Equal(t, "fr", subject.Language(&metav1.ObjectMeta{}, ns))
+	assert.Equal(t, "", subject.Language(&metav1.ObjectMeta{}))
+
+	var nilWarner *Warner
+	assert.Equal(t, "", nilWarner.Language(user))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	agentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/controllers"
+)
+
+const usageProfileDiffCommand = "usage-profile-diff"
+
+// placeholderProfileUID is the UID used for the controller reference of resources of ZoneUsageProfiles not yet existing in the cluster.
+// The server-side dry run requires a non-empty UID.
+const placeholderProfileUID = types.UID("00000000-0000-0000-0000-000000000000")
+
+// runUsageProfileDiff runs the usage-profile-diff subcommand.
+// It prints the changes applying a ZoneUsageProfile would make to the namespaces of the organizations, without mutating anything.
+// Namespaces not reached by the current stage of the rollout of the ZoneUsageProfile are diffed against the last completely rolled out revision.
+// The profile is read from the file given with -f, or from the cluster if only -name is given.
+func runUsageProfileDiff(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(usageProfileDiffCommand, flag.ContinueOnError)
+	configFilePath := fs.String("config-file", "./config.yaml", "Path to the configuration file")
+	profileFile := fs.String("f", "", "Path to a ZoneUsageProfile manifest to diff against the cluster")
+	profileName := fs.String("name", "", "Name of the ZoneUsageProfile in the cluster to diff. Ignored if -f is set")
+	selectedUsageProfile := fs.String("usage-profile", "", "UsageProfile to use for organizations not selecting a profile with the UsageProfileLabel. Applies all profiles to those organizations if empty.")
+	upstreamZoneIdentifier := fs.String("upstream-zone-identifier", "", "Identifies the zone in templated resources")
+	disablePruning := fs.Bool("disable-usage-profile-pruning", false, "Do not report the deletion of resources no longer defined in the ZoneUsageProfile or no longer selected for a namespace")
+	output := fs.String("o", "yaml", "Output format, one of yaml or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "yaml" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	if *profileFile == "" && *profileName == "" {
+		return errors.New("either -f or -name must be set")
+	}
+
+	conf, _, err := ConfigFromFile(*configFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("unable to get kubeconfig: %w", err)
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("unable to create client: %w", err)
+	}
+
+	profile, err := usageProfileToDiff(ctx, c, *profileFile, *profileName)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := (&controllers.ZoneUsageProfileApplyReconciler{
+		Client: c,
+		Scheme: scheme,
+
+		OrganizationLabel: conf.OrganizationLabel,
+		Transformers:      usageProfileTransformers(conf, *upstreamZoneIdentifier),
+
+		SelectedProfile:   *selectedUsageProfile,
+		UsageProfileLabel: conf.UsageProfileLabel,
+		DisablePruning:    *disablePruning,
+		Rollout:           conf.UsageProfileRollout,
+	}).DryRun(ctx, profile)
+	if err != nil {
+		return fmt.Errorf("unable to dry run ZoneUsageProfile: %w", err)
+	}
+
+	var rendered []byte
+	if *output == "json" {
+		rendered, err = json.MarshalIndent(dryRun, "", "  ")
+		rendered = append(rendered, '\n')
+	} else {
+		rendered, err = yaml.Marshal(dryRun)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to render dry run: %w", err)
+	}
+	_, err = out.Write(rendered)
+	return err
+}
+
+// usageProfileToDiff returns the ZoneUsageProfile read from the given file, or the ZoneUsageProfile with the given name from the cluster.
+// The UID and status of a ZoneUsageProfile read from a file are taken from the ZoneUsageProfile with the same name in the cluster,
+// so that resources applied by it are updated and pruned instead of reported as conflicts.
+// It is diffed as the next generation of the ZoneUsageProfile in the cluster, starting a new rollout.
+func usageProfileToDiff(ctx context.Context, c client.Client, file, name string) (agentv1.ZoneUsageProfile, error) {
+	var profile agentv1.ZoneUsageProfile
+	if file == "" {
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, &profile); err != nil {
+			return profile, fmt.Errorf("unable to get ZoneUsageProfile: %w", err)
+		}
+		return profile, nil
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return profile, fmt.Errorf("unable to read ZoneUsageProfile: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(raw, &profile); err != nil {
+		return profile, fmt.Errorf("unable to parse ZoneUsageProfile: %w", err)
+	}
+
+	var existing agentv1.ZoneUsageProfile
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&profile), &existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return profile, fmt.Errorf("unable to get ZoneUsageProfile: %w", err)
+		}
+		profile.UID = placeholderProfileUID
+		return profile, nil
+	}
+	profile.UID = existing.UID
+	profile.Generation = existing.Generation + 1
+	profile.Status = existing.Status
+	return profile, nil
+}
@@ -34,6 +34,10 @@ type NamespaceMetadataValidator struct {
 	// AllowedLabels is a list of labels that are allowed on the namespace.
 	// Supports '*' and '?' wildcards.
 	AllowedLabels []string
+	// ProtectedAnnotations is a list of annotations that can only be changed by skipped (privileged) users,
+	// even if they match AllowedAnnotations.
+	// Supports '*' and '?' wildcards.
+	ProtectedAnnotations []string
 }
 
 // Handle handles the admission requests
@@ -74,6 +78,9 @@ func (v *NamespaceMetadataValidator) handle(ctx context.Context, req admission.R
 		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode object from request: %w", err))
 	}
 
+	if err := validateProtectedKeys(oldObj.GetAnnotations(), newObj.GetAnnotations(), v.ProtectedAnnotations, "annotation"); err != nil {
+		return admission.Denied(fmt.Sprintf("The request was denied: %v. Only privileged users can change these annotations.", err))
+	}
 	if err := validateChangedMap(oldObj.GetAnnotations(), newObj.GetAnnotations(), v.AllowedAnnotations, "annotation"); err != nil {
 		return admission.Denied(formatDeniedMessage(err, "annotations", v.AllowedAnnotations, newObj.GetAnnotations(), oldObj.GetAnnotations()))
 	}
@@ -111,6 +118,19 @@ func validateChangedMap(old, new map[string]string, allowedKeys []string, errObj
 	return multierr.Combine(errs...)
 }
 
+func validateProtectedKeys(old, new map[string]string, protectedKeys []string, errObjectRef string) error {
+	changed := changedKeys(old, new)
+	errs := make([]error, 0, len(changed))
+	for _, k := range changed {
+		protected := slices.ContainsFunc(protectedKeys, func(p string) bool { return wildcard.Match(p, k) })
+		if protected {
+			errs = append(errs, fmt.Errorf("%s %q is protected", errObjectRef, k))
+		}
+	}
+
+	return multierr.Combine(errs...)
+}
+
 func changedKeys(a, b map[string]string) []string {
 	changed := sets.New[string]()
 
@@ -18,6 +18,7 @@ func Test_NamespaceMetadataValidator_Handle(t *testing.T) {
 		reservedNamespaces []string
 		allowedAnnotations []string
 		allowedLabels      []string
+		protected          []string
 
 		object client.Object
 		oldObj client.Object
@@ -113,6 +114,30 @@ func Test_NamespaceMetadataValidator_Handle(t *testing.T) {
 			allowedAnnotations: []string{"allowed"},
 			allowed:            false,
 		},
+		{
+			name:               "set protected annotation",
+			object:             newNamespace("test-namespace", nil, map[string]string{"validate-request-ratio.appuio.io/limit": "2Gi"}),
+			oldObj:             newNamespace("test-namespace", nil, nil),
+			allowedAnnotations: []string{"*"},
+			protected:          []string{"validate-request-ratio.appuio.io/limit"},
+			allowed:            false,
+		},
+		{
+			name:               "remove protected annotation",
+			object:             newNamespace("test-namespace", nil, nil),
+			oldObj:             newNamespace("test-namespace", nil, map[string]string{"validate-request-ratio.appuio.io/limit": "2Gi"}),
+			allowedAnnotations: []string{"*"},
+			protected:          []string{"validate-request-ratio.appuio.io/*"},
+			allowed:            false,
+		},
+		{
+			name:               "keep protected annotation",
+			object:             newNamespace("test-namespace", nil, map[string]string{"validate-request-ratio.appuio.io/limit": "2Gi", "allowed": ""}),
+			oldObj:             newNamespace("test-namespace", nil, map[string]string{"validate-request-ratio.appuio.io/limit": "2Gi"}),
+			allowedAnnotations: []string{"allowed"},
+			protected:          []string{"validate-request-ratio.appuio.io/limit"},
+			allowed:            true,
+		},
 	}
 
 	_, scheme, decoder := prepareClient(t)
@@ -127,6 +152,8 @@ func Test_NamespaceMetadataValidator_Handle(t *testing.T) {
 				ReservedNamespaces: tc.reservedNamespaces,
 				AllowedAnnotations: tc.allowedAnnotations,
 				AllowedLabels:      tc.allowedLabels,
+
+				ProtectedAnnotations: tc.protected,
 			}
 
 			amr := admissionRequestForObjectWithOldObject(t, tc.object, tc.oldObj, scheme)
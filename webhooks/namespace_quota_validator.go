@@ -2,28 +2,29 @@ package webhooks
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	userv1 "github.com/openshift/api/user/v1"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
-	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
+	"github.com/appuio/appuio-cloud-agent/nsquota"
 	"github.com/appuio/appuio-cloud-agent/skipper"
 )
 
-// +kubebuilder:webhook:path=/validate-namespace-quota,name=validate-namespace-quota.appuio.io,admissionReviewVersions=v1,sideEffects=none,mutating=false,failurePolicy=Fail,groups="",resources=namespaces,verbs=create,versions=v1,matchPolicy=equivalent
-// +kubebuilder:webhook:path=/validate-namespace-quota,name=validate-namespace-quota-projectrequests.appuio.io,admissionReviewVersions=v1,sideEffects=none,mutating=false,failurePolicy=Fail,groups=project.openshift.io,resources=projectrequests,verbs=create,versions=v1,matchPolicy=equivalent
+// +kubebuilder:webhook:path=/validate-namespace-quota,name=validate-namespace-quota.appuio.io,admissionReviewVersions=v1,sideEffects=NoneOnDryRun,mutating=false,failurePolicy=Fail,groups="",resources=namespaces,verbs=create,versions=v1,matchPolicy=equivalent
+// +kubebuilder:webhook:path=/validate-namespace-quota,name=validate-namespace-quota-projectrequests.appuio.io,admissionReviewVersions=v1,sideEffects=NoneOnDryRun,mutating=false,failurePolicy=Fail,groups=project.openshift.io,resources=projectrequests,verbs=create,versions=v1,matchPolicy=equivalent
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 // +kubebuilder:rbac:groups=user.openshift.io,resources=users,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotaoverrides,verbs=get;list;watch
 
 // NamespaceQuotaValidator checks if a user is allowed to create a namespace.
 // The user or the namespace must have a label with the organization name.
@@ -33,6 +34,13 @@ type NamespaceQuotaValidator struct {
 
 	// Client is used to fetch namespace counts
 	Client client.Reader
+	// APIReader is used to count namespaces bypassing the cache if Reservations is set.
+	// Defaults to Client.
+	APIReader client.Reader
+
+	// Reservations counts admitted namespaces not yet visible to concurrent requests.
+	// Concurrent requests can exceed the quota if nil.
+	Reservations *NamespaceReservations
 
 	Skipper skipper.Skipper
 
@@ -46,8 +54,12 @@ type NamespaceQuotaValidator struct {
 	// SelectedProfile is the name of the ZoneUsageProfile to use for the quota
 	// An empty string means that the legacy namespace quota is used if set.
 	SelectedProfile string
+	// UsageProfileLabel is the label on the namespaces of an organization selecting the ZoneUsageProfile of the organization.
+	// The label is ignored on the namespace being created. SelectedProfile is used if the organization does not select a profile.
+	UsageProfileLabel string
 
-	// QuotaOverrideNamespace is the namespace in which the quota overrides are stored
+	// QuotaOverrideNamespace is the namespace in which the deprecated quota override ConfigMaps are stored.
+	// OrganizationQuotaOverride resources take precedence over the ConfigMaps.
 	QuotaOverrideNamespace string
 
 	// EnableLegacyNamespaceQuota enables the legacy namespace quota.
@@ -55,6 +67,10 @@ type NamespaceQuotaValidator struct {
 	// LegacyNamespaceQuota is the namespace quota for legacy mode.
 	// It is used if no ZoneUsageProfile is selected.
 	LegacyNamespaceQuota int
+
+	// WarnUsagePercentage is the percentage of the namespace quota above which a warning is returned when creating a namespace.
+	// No warnings are returned if 0.
+	WarnUsagePercentage int
 }
 
 // Handle handles the admission requests
@@ -118,51 +134,88 @@ func (v *NamespaceQuotaValidator) handle(ctx context.Context, req admission.Requ
 		return admission.Allowed("skipped quota validation")
 	}
 
-	var nsCountLimit int
-	if v.EnableLegacyNamespaceQuota {
-		nsCountLimit = v.LegacyNamespaceQuota
-	} else {
-		if v.SelectedProfile == "" {
-			return admission.Denied("No ZoneUsageProfile selected")
+	limit, err := v.resolver().Limit(ctx, organizationName)
+	if errors.Is(err, nsquota.ErrNoProfileSelected) {
+		return admission.Denied("No ZoneUsageProfile selected")
+	}
+	if err != nil {
+		l.Error(err, "error while resolving namespace quota")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	nsCountLimit := limit.Namespaces
+
+	denied := admission.Denied(fmt.Sprintf(
+		"You cannot create more than %d namespaces for organization %q. Please contact support to have your quota raised.",
+		nsCountLimit, organizationName))
+
+	var used int
+	// Dry run requests only count the namespaces, they must not reserve a namespace.
+	if v.Reservations != nil && !ptr.Deref(req.DryRun, false) {
+		u, ok, err := v.Reservations.Reserve(ctx, organizationName, rawObject.GetName(), nsCountLimit, func(ctx context.Context) (sets.Set[string], error) {
+			return v.organizationNamespaces(ctx, organizationName)
+		})
+		if err != nil {
+			l.Error(err, "error while reserving namespace")
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
-
-		var profile cloudagentv1.ZoneUsageProfile
-		if err := v.Client.Get(ctx, types.NamespacedName{Name: v.SelectedProfile}, &profile); err != nil {
-			l.Error(err, "error while fetching zone usage profile")
+		if !ok {
+			return denied
+		}
+		used = u
+	} else {
+		// count namespaces for organization
+		var nsList corev1.NamespaceList
+		if err := v.Client.List(ctx, &nsList, client.MatchingLabels{
+			v.OrganizationLabel: organizationName,
+		}); err != nil {
+			l.Error(err, "error while listing namespaces")
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
-		nsCountLimit = profile.Spec.UpstreamSpec.NamespaceCount
+		if len(nsList.Items) >= nsCountLimit {
+			return denied
+		}
+		used = len(nsList.Items) + 1
 	}
 
-	var overrideCM corev1.ConfigMap
-	if err := v.Client.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("override-%s", organizationName), Namespace: v.QuotaOverrideNamespace}, &overrideCM); err == nil {
-		if overrideCM.Data["namespaceQuota"] != "" {
-			nsCountLimit, err = strconv.Atoi(overrideCM.Data["namespaceQuota"])
-			if err != nil {
-				l.Error(err, "error while parsing namespace quota")
-				return admission.Errored(http.StatusInternalServerError, err)
-			}
-		}
-	} else if !apierrors.IsNotFound(err) {
-		l.Error(err, "error while fetching override configmap")
-		return admission.Errored(http.StatusInternalServerError, err)
+	res := admission.Allowed("allowed")
+	if v.WarnUsagePercentage > 0 && used*100 >= nsCountLimit*v.WarnUsagePercentage {
+		res = res.WithWarnings(fmt.Sprintf(
+			"Organization %q is using %d of %d allowed namespaces. Please contact support if you need more namespaces.",
+			organizationName, used, nsCountLimit))
 	}
+	return res
+}
 
-	// count namespaces for organization
+// resolver returns the namespace quota resolver configured by the validator.
+func (v *NamespaceQuotaValidator) resolver() nsquota.Resolver {
+	return nsquota.Resolver{
+		Client:                     v.Client,
+		SelectedProfile:            v.SelectedProfile,
+		UsageProfileLabel:          v.UsageProfileLabel,
+		OrganizationLabel:          v.OrganizationLabel,
+		QuotaOverrideNamespace:     v.QuotaOverrideNamespace,
+		EnableLegacyNamespaceQuota: v.EnableLegacyNamespaceQuota,
+		LegacyNamespaceQuota:       v.LegacyNamespaceQuota,
+	}
+}
+
+// organizationNamespaces returns the names of the namespaces of the given organization using the APIReader.
+func (v *NamespaceQuotaValidator) organizationNamespaces(ctx context.Context, organization string) (sets.Set[string], error) {
+	reader := v.APIReader
+	if reader == nil {
+		reader = v.Client
+	}
 	var nsList corev1.NamespaceList
-	if err := v.Client.List(ctx, &nsList, client.MatchingLabels{
-		v.OrganizationLabel: organizationName,
+	if err := reader.List(ctx, &nsList, client.MatchingLabels{
+		v.OrganizationLabel: organization,
 	}); err != nil {
-		l.Error(err, "error while listing namespaces")
-		return admission.Errored(http.StatusInternalServerError, err)
+		return nil, fmt.Errorf("error while listing namespaces: %w", err)
 	}
-	if len(nsList.Items) >= nsCountLimit {
-		return admission.Denied(fmt.Sprintf(
-			"You cannot create more than %d namespaces for organization %q. Please contact support to have your quota raised.",
-			nsCountLimit, organizationName))
+	names := sets.New[string]()
+	for _, ns := range nsList.Items {
+		names.Insert(ns.Name)
 	}
-
-	return admission.Allowed("allowed")
+	return names, nil
 }
 
 // logAdmissionResponse logs the admission response to the logger derived from the given context and returns it unchanged.
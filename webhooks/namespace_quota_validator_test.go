@@ -3,6 +3,7 @@ package webhooks
 import (
 	"context"
 	"testing"
+	"time"
 
 	controlv1 "github.com/appuio/control-api/apis/v1"
 	"github.com/go-logr/logr/testr"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -33,6 +35,8 @@ func TestNamespaceQuotaValidator_Handle(t *testing.T) {
 		matchMessage        string
 		disableProfile      bool
 		legacyQuota         int
+		warnPercentage      int
+		matchWarning        string
 	}{
 		"Allow Namespace": {
 			initObjects: []client.Object{
@@ -95,6 +99,93 @@ func TestNamespaceQuotaValidator_Handle(t *testing.T) {
 			allowed: true,
 		},
 
+		"Allow Namespace OrganizationQuotaOverride": {
+			initObjects: []client.Object{
+				newNamespace("a", map[string]string{orgLabel: "testorg"}, nil),
+				newNamespace("b", map[string]string{orgLabel: "testorg"}, nil),
+				newNamespace("c", map[string]string{orgLabel: "testorg"}, nil),
+				newQuotaOverride("testorg", 4, nil),
+			},
+			object: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Labels: map[string]string{
+						orgLabel: "testorg",
+					},
+				},
+			},
+			allowed: true,
+		},
+		"Deny Namespace OrganizationQuotaOverride Precedence": {
+			initObjects: []client.Object{
+				newNamespace("a", map[string]string{orgLabel: "testorg"}, nil),
+				newQuotaOverride("testorg", 1, nil),
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "override-testorg",
+						Namespace: "test",
+					},
+					Data: map[string]string{
+						"namespaceQuota": "4",
+					},
+				},
+			},
+			object: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Labels: map[string]string{
+						orgLabel: "testorg",
+					},
+				},
+			},
+			allowed:      false,
+			matchMessage: "You cannot create more than 1 namespaces",
+		},
+		"Deny Namespace OrganizationQuotaOverride Expired": {
+			initObjects: []client.Object{
+				newNamespace("a", map[string]string{orgLabel: "testorg"}, nil),
+				newNamespace("b", map[string]string{orgLabel: "testorg"}, nil),
+				newQuotaOverride("testorg", 4, &metav1.Time{Time: time.Now().Add(-time.Hour)}),
+			},
+			object: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Labels: map[string]string{
+						orgLabel: "testorg",
+					},
+				},
+			},
+			allowed:      false,
+			matchMessage: "You cannot create more than 2 namespaces",
+		},
+
+		"Warn Namespace UsageAbovePercentage": {
+			initObjects: []client.Object{newNamespace("a", map[string]string{orgLabel: "testorg"}, nil)},
+			object: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Labels: map[string]string{
+						orgLabel: "testorg",
+					},
+				},
+			},
+			allowed:        true,
+			warnPercentage: 100,
+			matchWarning:   `Organization "testorg" is using 2 of 2 allowed namespaces`,
+		},
+		"Allow Namespace UsageBelowPercentage": {
+			object: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+					Labels: map[string]string{
+						orgLabel: "testorg",
+					},
+				},
+			},
+			allowed:        true,
+			warnPercentage: 80,
+		},
+
 		"Deny Namespace TooMany": {
 			initObjects: []client.Object{newNamespace("a", map[string]string{orgLabel: "testorg"}, nil), newNamespace("b", map[string]string{orgLabel: "testorg"}, nil)},
 			object: &corev1.Namespace{
@@ -342,6 +433,8 @@ func TestNamespaceQuotaValidator_Handle(t *testing.T) {
 				SelectedProfile:        "test",
 				QuotaOverrideNamespace: "test",
 				LegacyNamespaceQuota:   test.legacyQuota,
+
+				WarnUsagePercentage: test.warnPercentage,
 			}
 
 			if test.legacyQuota > 0 {
@@ -367,6 +460,103 @@ func TestNamespaceQuotaValidator_Handle(t *testing.T) {
 			if test.matchMessage != "" {
 				require.Contains(t, res.Result.Message, test.matchMessage)
 			}
+			if test.matchWarning != "" {
+				require.Len(t, res.Warnings, 1)
+				require.Contains(t, res.Warnings[0], test.matchWarning)
+			} else {
+				require.Empty(t, res.Warnings)
+			}
 		})
 	}
 }
+
+func newQuotaOverride(org string, namespaceQuota int, expiresAt *metav1.Time) *cloudagentv1.OrganizationQuotaOverride {
+	return &cloudagentv1.OrganizationQuotaOverride{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: org,
+		},
+		Spec: cloudagentv1.OrganizationQuotaOverrideSpec{
+			Reason:         "test",
+			ExpiresAt:      expiresAt,
+			NamespaceQuota: &namespaceQuota,
+		},
+	}
+}
+
+func TestNamespaceQuotaValidator_Handle_Reservations(t *testing.T) {
+	ctx := log.IntoContext(context.Background(), testr.New(t))
+
+	const orgLabel = "test.io/organization"
+
+	c, scheme, dec := prepareClient(t, newNamespace("a", map[string]string{orgLabel: "testorg"}, nil))
+	subject := &NamespaceQuotaValidator{
+		Decoder: dec,
+		Client:  c,
+		Skipper: skipper.StaticSkipper{ShouldSkip: false},
+
+		OrganizationLabel: orgLabel,
+
+		EnableLegacyNamespaceQuota: true,
+		LegacyNamespaceQuota:       2,
+
+		Reservations: &NamespaceReservations{},
+	}
+
+	ns := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{orgLabel: "testorg"},
+			},
+		}
+	}
+
+	dryRun := admissionRequestForObject(t, ns("x"), scheme)
+	dryRun.DryRun = ptr.To(true)
+	res := subject.Handle(ctx, dryRun)
+	require.True(t, res.Allowed)
+	// dry run requests do not reserve a namespace
+	res = subject.Handle(ctx, admissionRequestForObject(t, ns("b"), scheme))
+	require.True(t, res.Allowed)
+	// "b" was admitted but is not yet visible
+	res = subject.Handle(ctx, admissionRequestForObject(t, ns("c"), scheme))
+	require.False(t, res.Allowed)
+	require.Contains(t, res.Result.Message, "You cannot create more than 2 namespaces")
+}
+
+func TestNamespaceQuotaValidator_Handle_UsageProfileLabel(t *testing.T) {
+	ctx := log.IntoContext(context.Background(), testr.New(t))
+
+	const orgLabel = "test.io/organization"
+	const profileLabel = "test.io/usage-profile"
+
+	profile := func(name string, count int) *cloudagentv1.ZoneUsageProfile {
+		return &cloudagentv1.ZoneUsageProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: cloudagentv1.ZoneUsageProfileSpec{
+				UpstreamSpec: controlv1.UsageProfileSpec{NamespaceCount: count},
+			},
+		}
+	}
+	c, scheme, dec := prepareClient(t,
+		newNamespace("a", map[string]string{orgLabel: "large-org", profileLabel: "large"}, nil),
+		newNamespace("b", map[string]string{orgLabel: "default-org"}, nil),
+		profile("default", 1),
+		profile("large", 2),
+	)
+	subject := &NamespaceQuotaValidator{
+		Decoder: dec,
+		Client:  c,
+		Skipper: skipper.StaticSkipper{ShouldSkip: false},
+
+		OrganizationLabel: orgLabel,
+		SelectedProfile:   "default",
+		UsageProfileLabel: profileLabel,
+	}
+
+	res := subject.Handle(ctx, admissionRequestForObject(t, newNamespace("test", map[string]string{orgLabel: "large-org"}, nil), scheme))
+	require.True(t, res.Allowed, "should use the profile selected by the organization")
+
+	res = subject.Handle(ctx, admissionRequestForObject(t, newNamespace("test", map[string]string{orgLabel: "default-org", profileLabel: "large"}, nil), scheme))
+	require.False(t, res.Allowed, "should ignore the profile label of the namespace being created")
+}
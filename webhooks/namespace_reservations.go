@@ -0,0 +1,326 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
+
+const (
+	// namespaceReservationAnnotationPrefix is the prefix of the annotations on the shared lease recording a reservation.
+	// The annotation key is the prefix followed by the namespace name, the value is the RFC3339 expiry of the reservation.
+	namespaceReservationAnnotationPrefix = "namespace-quota.appuio.io/"
+	// namespaceReservationLeasePrefix is the prefix of the name of the shared lease of an organization.
+	namespaceReservationLeasePrefix = "namespace-quota-"
+
+	defaultNamespaceReservationTTL = 15 * time.Second
+	maxNamespaceReservationRetries = 5
+)
+
+// NamespaceReservations keeps track of admitted namespaces which are not yet visible when listing namespaces.
+// Reservations are serialized per organization within a replica.
+// If a Client is set, reservations are shared between replicas through a Lease per organization
+// which is updated with optimistic concurrency.
+// NamespaceReservations must be added to the manager to remove reservations of created namespaces and empty Leases.
+type NamespaceReservations struct {
+	// Client is used to store the reservations shared between replicas.
+	// Reservations are only kept in memory if nil.
+	Client client.Client
+	// APIReader is used to read the shared reservations bypassing the cache.
+	// Defaults to Client.
+	APIReader client.Reader
+	// Namespace is the namespace the shared reservation leases are stored in.
+	Namespace string
+
+	// TTL is the time a reservation is counted for.
+	// It must be longer than the time it takes for an admitted namespace to show up when listing namespaces bypassing the cache.
+	// A reservation of a namespace whose creation is rejected after admission, for example by another webhook, blocks the quota until it expires.
+	// Retrying the creation of the same namespace reuses the reservation, creating a namespace with another name is denied until then.
+	// Defaults to 15 seconds.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	orgLocks map[string]*sync.Mutex
+	local    map[string]map[string]time.Time
+
+	now func() time.Time
+}
+
+var _ manager.Runnable = &NamespaceReservations{}
+
+// Reserve reserves a namespace with the given name for the organization.
+// existing must return the names of the existing namespaces of the organization and should bypass any cache.
+// The reservation is denied if the number of existing namespaces and reservations already reaches the limit.
+// Returns the number of existing and reserved namespaces including the new reservation if it was granted.
+func (r *NamespaceReservations) Reserve(ctx context.Context, org, name string, limit int, existing func(context.Context) (sets.Set[string], error)) (int, bool, error) {
+	unlock := r.lockOrg(org)
+	defer unlock()
+
+	if r.Client == nil {
+		names, err := existing(ctx)
+		if err != nil {
+			return 0, false, err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.local == nil {
+			r.local = make(map[string]map[string]time.Time)
+		}
+		if r.local[org] == nil {
+			r.local[org] = make(map[string]time.Time)
+		}
+		used, ok := r.reserve(r.local[org], name, limit, names)
+		return used, ok, nil
+	}
+
+	for i := 0; i < maxNamespaceReservationRetries; i++ {
+		used, ok, err := r.reserveShared(ctx, org, name, limit, existing)
+		if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+			continue
+		}
+		return used, ok, err
+	}
+	return 0, false, fmt.Errorf("failed to reserve namespace %q for organization %q: too many conflicts", name, org)
+}
+
+// reserveShared reserves the namespace in the lease of the organization.
+// Returns a conflict error if the lease was modified concurrently.
+func (r *NamespaceReservations) reserveShared(ctx context.Context, org, name string, limit int, existing func(context.Context) (sets.Set[string], error)) (int, bool, error) {
+	lease := coordinationv1.Lease{}
+	err := r.reader().Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: namespaceReservationLeasePrefix + org}, &lease)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return 0, false, fmt.Errorf("failed to get reservation lease: %w", err)
+	}
+	create := apierrors.IsNotFound(err)
+
+	// List after reading the lease so namespaces whose reservation was pruned concurrently are seen.
+	names, err := existing(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	reservations := leaseReservations(lease)
+	used, ok := r.reserve(reservations, name, limit, names)
+	if !ok {
+		return used, false, nil
+	}
+	setLeaseReservations(&lease, reservations)
+
+	if create {
+		lease.Name = namespaceReservationLeasePrefix + org
+		lease.Namespace = r.Namespace
+		return used, true, r.Client.Create(ctx, &lease)
+	}
+	return used, true, r.Client.Update(ctx, &lease)
+}
+
+// reserve prunes expired reservations and reservations of existing namespaces and adds a reservation for the given name
+// if the existing namespaces and reservations are below the limit.
+// Returns the number of existing and reserved namespaces.
+func (r *NamespaceReservations) reserve(reservations map[string]time.Time, name string, limit int, existing sets.Set[string]) (int, bool) {
+	now := r.clock()
+	ttl := r.ttl()
+
+	for ns, expiry := range reservations {
+		if existing.Has(ns) || now.After(expiry) {
+			delete(reservations, ns)
+		}
+	}
+
+	// A retried request for an already reserved namespace is counted in the reservations.
+	delete(reservations, name)
+	if existing.Len()+len(reservations) >= limit {
+		return existing.Len() + len(reservations), false
+	}
+	reservations[name] = now.Add(ttl)
+	return existing.Len() + len(reservations), true
+}
+
+// Start prunes the reservations every TTL until the context is cancelled.
+// Implements manager.Runnable.
+func (r *NamespaceReservations) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.ttl())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Prune(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "failed to prune namespace reservations")
+			}
+		}
+	}
+}
+
+// Prune removes expired reservations and reservations of namespaces which exist.
+// Shared reservation Leases without reservations are deleted.
+func (r *NamespaceReservations) Prune(ctx context.Context) error {
+	if r.Client == nil {
+		r.pruneLocal()
+		return nil
+	}
+
+	leases := coordinationv1.LeaseList{}
+	if err := r.reader().List(ctx, &leases, client.InNamespace(r.Namespace)); err != nil {
+		return fmt.Errorf("failed to list reservation leases: %w", err)
+	}
+	var errs []error
+	for _, lease := range leases.Items {
+		org, ok := strings.CutPrefix(lease.Name, namespaceReservationLeasePrefix)
+		if !ok {
+			continue
+		}
+		if err := r.pruneShared(ctx, org); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune reservations of organization %q: %w", org, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pruneLocal removes expired in-memory reservations.
+func (r *NamespaceReservations) pruneLocal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock()
+	for org, reservations := range r.local {
+		for ns, expiry := range reservations {
+			if now.After(expiry) {
+				delete(reservations, ns)
+			}
+		}
+		if len(reservations) == 0 {
+			delete(r.local, org)
+		}
+	}
+}
+
+// pruneShared removes expired reservations and reservations of existing namespaces from the lease of the organization.
+// The lease is deleted if no reservations are left. Concurrent modifications of the lease are left for the next run.
+func (r *NamespaceReservations) pruneShared(ctx context.Context, org string) error {
+	unlock := r.lockOrg(org)
+	defer unlock()
+
+	reader := r.reader()
+	lease := coordinationv1.Lease{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: namespaceReservationLeasePrefix + org}, &lease); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	now := r.clock()
+	reservations := leaseReservations(lease)
+	pruned := maps.Clone(reservations)
+	for ns, expiry := range reservations {
+		if now.After(expiry) {
+			delete(pruned, ns)
+			continue
+		}
+		err := reader.Get(ctx, client.ObjectKey{Name: ns}, &corev1.Namespace{})
+		if err == nil {
+			delete(pruned, ns)
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get namespace %q: %w", ns, err)
+		}
+	}
+
+	var err error
+	if len(pruned) == 0 {
+		err = client.IgnoreNotFound(r.Client.Delete(ctx, &lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion}))
+	} else if len(pruned) < len(reservations) {
+		setLeaseReservations(&lease, pruned)
+		err = r.Client.Update(ctx, &lease)
+	}
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// reader returns the APIReader or the Client if unset.
+func (r *NamespaceReservations) reader() client.Reader {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
+
+// ttl returns the TTL or the default TTL if unset.
+func (r *NamespaceReservations) ttl() time.Duration {
+	if r.TTL == 0 {
+		return defaultNamespaceReservationTTL
+	}
+	return r.TTL
+}
+
+// clock returns the current time.
+func (r *NamespaceReservations) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// leaseReservations returns the reservations recorded in the annotations of the given lease.
+func leaseReservations(lease coordinationv1.Lease) map[string]time.Time {
+	reservations := make(map[string]time.Time)
+	for k, v := range lease.Annotations {
+		ns, ok := strings.CutPrefix(k, namespaceReservationAnnotationPrefix)
+		if !ok {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			continue
+		}
+		reservations[ns] = expiry
+	}
+	return reservations
+}
+
+// setLeaseReservations replaces the reservations recorded in the annotations of the given lease.
+// Other annotations are kept.
+func setLeaseReservations(lease *coordinationv1.Lease, reservations map[string]time.Time) {
+	annotations := make(map[string]string, len(reservations))
+	for k, v := range lease.Annotations {
+		if !strings.HasPrefix(k, namespaceReservationAnnotationPrefix) {
+			annotations[k] = v
+		}
+	}
+	for ns, expiry := range reservations {
+		annotations[namespaceReservationAnnotationPrefix+ns] = expiry.Format(time.RFC3339)
+	}
+	lease.Annotations = annotations
+}
+
+// lockOrg locks the given organization within this replica and returns the function to unlock it.
+func (r *NamespaceReservations) lockOrg(org string) func() {
+	r.mu.Lock()
+	if r.orgLocks == nil {
+		r.orgLocks = make(map[string]*sync.Mutex)
+	}
+	l, ok := r.orgLocks[org]
+	if !ok {
+		l = &sync.Mutex{}
+		r.orgLocks[org] = l
+	}
+	r.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
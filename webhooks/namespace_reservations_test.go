@@ -0,0 +1,196 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestNamespaceReservations_Reserve_Concurrent(t *testing.T) {
+	const limit = 3
+	existing := func(context.Context) (sets.Set[string], error) {
+		return sets.New("existing"), nil
+	}
+
+	c, _, _ := prepareClient(t)
+	tcs := map[string][]*NamespaceReservations{
+		"in memory": {{}},
+		"shared between replicas": {
+			{Client: c, Namespace: "quota"},
+			{Client: c, Namespace: "quota"},
+			{Client: c, Namespace: "quota"},
+		},
+	}
+
+	for name, replicas := range tcs {
+		t.Run(name, func(t *testing.T) {
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					r := replicas[i%len(replicas)]
+					// Conflicts between replicas are retried a limited number of times, retry like a client would.
+					for {
+						_, ok, err := r.Reserve(context.Background(), "org", fmt.Sprintf("ns-%d", i), limit, existing)
+						if err != nil {
+							continue
+						}
+						if ok {
+							allowed.Add(1)
+						}
+						return
+					}
+				}(i)
+			}
+			wg.Wait()
+			assert.EqualValues(t, limit-1, allowed.Load())
+
+			_, ok, err := replicas[0].Reserve(context.Background(), "other", "ns", limit, existing)
+			require.NoError(t, err)
+			assert.True(t, ok, "other organizations should not be affected")
+		})
+	}
+}
+
+func TestNamespaceReservations_Reserve_Prune(t *testing.T) {
+	c, _, _ := prepareClient(t)
+
+	for name, subject := range map[string]*NamespaceReservations{
+		"in memory": {},
+		"shared":    {Client: c, Namespace: "quota"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			subject.now = func() time.Time { return now }
+			subject.TTL = time.Minute
+
+			existing := sets.New[string]()
+			list := func(context.Context) (sets.Set[string], error) {
+				return existing.Clone(), nil
+			}
+
+			used, ok, err := subject.Reserve(context.Background(), "org", "a", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, 1, used)
+			_, ok, err = subject.Reserve(context.Background(), "org", "a", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok, "retried requests should not be counted twice")
+			_, ok, err = subject.Reserve(context.Background(), "org", "b", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			_, ok, err = subject.Reserve(context.Background(), "org", "c", 2, list)
+			require.NoError(t, err)
+			assert.False(t, ok, "reservations should be counted")
+
+			existing.Insert("a")
+			_, ok, err = subject.Reserve(context.Background(), "org", "c", 2, list)
+			require.NoError(t, err)
+			assert.False(t, ok, "created namespaces should be counted once")
+
+			now = now.Add(2 * time.Minute)
+			_, ok, err = subject.Reserve(context.Background(), "org", "c", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok, "expired reservations should not be counted")
+		})
+	}
+
+	var lease coordinationv1.Lease
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "quota", Name: "namespace-quota-org"}, &lease))
+	assert.Equal(t, map[string]string{
+		"namespace-quota.appuio.io/c": "2024-01-01T00:03:00Z",
+	}, lease.Annotations)
+}
+
+func TestNamespaceReservations_Reserve_DeniedRetry(t *testing.T) {
+	c, _, _ := prepareClient(t)
+
+	for name, subject := range map[string]*NamespaceReservations{
+		"in memory": {},
+		"shared":    {Client: c, Namespace: "quota"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			subject.now = func() time.Time { return now }
+
+			list := func(context.Context) (sets.Set[string], error) {
+				return sets.New("existing"), nil
+			}
+
+			_, ok, err := subject.Reserve(context.Background(), "org", "rejected", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			// The creation of "rejected" is denied after admission, the namespace never shows up.
+
+			_, ok, err = subject.Reserve(context.Background(), "org", "rejected", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok, "retrying the same namespace should reuse the reservation")
+			_, ok, err = subject.Reserve(context.Background(), "org", "renamed", 2, list)
+			require.NoError(t, err)
+			assert.False(t, ok, "reservation should block other namespaces until it expires")
+
+			now = now.Add(defaultNamespaceReservationTTL + time.Second)
+			_, ok, err = subject.Reserve(context.Background(), "org", "renamed", 2, list)
+			require.NoError(t, err)
+			assert.True(t, ok, "expired reservation should not block other namespaces")
+		})
+	}
+}
+
+func TestNamespaceReservations_Prune(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, _, _ := prepareClient(t,
+		newNamespace("created", nil, nil),
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "namespace-quota-org",
+				Namespace: "quota",
+				Annotations: map[string]string{
+					"namespace-quota.appuio.io/created": "2024-01-01T00:00:10Z",
+					"namespace-quota.appuio.io/pending": "2024-01-01T00:00:10Z",
+					"namespace-quota.appuio.io/expired": "2023-12-31T23:59:50Z",
+				},
+			},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "namespace-quota-done",
+				Namespace: "quota",
+				Annotations: map[string]string{
+					"namespace-quota.appuio.io/created": "2024-01-01T00:00:10Z",
+				},
+			},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "unrelated",
+				Namespace: "quota",
+			},
+		},
+	)
+	subject := &NamespaceReservations{Client: c, Namespace: "quota", now: func() time.Time { return now }}
+
+	require.NoError(t, subject.Prune(context.Background()))
+
+	var lease coordinationv1.Lease
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "quota", Name: "namespace-quota-org"}, &lease))
+	assert.Equal(t, map[string]string{
+		"namespace-quota.appuio.io/pending": "2024-01-01T00:00:10Z",
+	}, lease.Annotations)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "quota", Name: "namespace-quota-done"}, &lease)
+	assert.True(t, apierrors.IsNotFound(err), "lease without reservations should be deleted")
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "quota", Name: "unrelated"}, &lease))
+}
@@ -3,42 +3,85 @@ package webhooks
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	userv1 "github.com/openshift/api/user/v1"
 	"gopkg.in/inf.v0"
 	admissionv1 "k8s.io/api/admission/v1"
-	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/appuio/appuio-cloud-agent/limits"
 	"github.com/appuio/appuio-cloud-agent/ratio"
+	"github.com/appuio/appuio-cloud-agent/skipper"
 )
 
-// +kubebuilder:webhook:path=/validate-request-ratio,name=validate-request-ratio.appuio.io,admissionReviewVersions=v1,sideEffects=none,mutating=false,failurePolicy=ignore,groups=*,resources=*,verbs=create;update,versions=*,matchPolicy=equivalent
+// +kubebuilder:webhook:path=/validate-request-ratio,name=validate-request-ratio.appuio.io,admissionReviewVersions=v1,sideEffects=none,mutating=false,failurePolicy=ignore,groups=*,resources=*;*/scale,verbs=create;update,versions=*,matchPolicy=equivalent
 
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=user.openshift.io,resources=users,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloudagent.appuio.io,resources=organizationquotaoverrides,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;replicasets,verbs=get
+// +kubebuilder:rbac:groups=apps.openshift.io,resources=deploymentconfigs,verbs=get
 
 // RatioValidator checks for every action in a namespace whether the Memory to CPU ratio limit is exceeded and will return a warning if it is.
+// Creating workloads is denied if the ratio drops below the hard limit of an enforced limit.
+// Requests to the scale subresource are projected onto the pod template of the scaled workload.
 type RatioValidator struct {
 	Decoder admission.Decoder
 	Client  client.Client
+	// APIReader is used to get the scaled workload of scale subresource requests bypassing the cache.
+	// Client is used if unset.
+	APIReader client.Reader
+
+	// Skipper allows skipping the enforcement of limits.
+	// Warnings are still emitted for skipped requests.
+	Skipper skipper.Skipper
 
 	Ratio              ratioFetcher
 	RatioLimits        limits.Limits
 	RatioWarnThreshold *inf.Dec
 
+	// OrganizationLabel is the namespace label identifying the organization of a namespace.
+	// Used to apply the memory per core limit of OrganizationQuotaOverrides. Organization overrides are ignored if empty.
+	OrganizationLabel string
+	// Aggregation must match the aggregation of the Ratio fetcher.
+	// If it is ratio.AggregationOrganization, the ratios of namespaces with the OrganizationLabel are checked against the limits of the organization.
+	Aggregation ratio.Aggregation
+
+	// NodeClassResolver resolves the node selector of the pod template.
+	// Only `.spec.nodeSelector` is used if unset.
+	NodeClassResolver ratio.NodeClassResolver
+
 	// DefaultNodeSelector is the default node selector to apply to pods
 	DefaultNodeSelector map[string]string
 	// DefaultNamespaceNodeSelectorAnnotation is the annotation to use for the default node selector
 	DefaultNamespaceNodeSelectorAnnotation string
+
+	// LimitAnalysis enables separate warnings for workloads without CPU or memory requests
+	// and for workloads whose memory to CPU limit ratio is below the fair use ratio.
+	LimitAnalysis bool
+
+	// Warner renders the warnings.
+	// Ratio.Warn is used if nil.
+	Warner *ratio.Warner
+
+	// PodTemplateExtractors are used to extract the pod template from the object in the request.
+	// Defaults to ratio.DefaultPodTemplateExtractors if nil.
+	PodTemplateExtractors ratio.PodTemplateExtractors
 }
 
 type ratioFetcher interface {
@@ -72,48 +115,75 @@ func (v *RatioValidator) Handle(ctx context.Context, req admission.Request) admi
 		return errored(http.StatusInternalServerError, err)
 	}
 
-	nodeSel, err := v.getNodeSelector(req)
+	ratioLimits, err := v.namespaceLimits(ctx, req.Namespace)
 	if err != nil {
-		l.Error(err, "failed to get node selector")
-		return errored(http.StatusBadRequest, err)
-	}
-	if len(nodeSel) == 0 {
-		sel, err := v.getDefaultNodeSelectorFromNamespace(ctx, req.Namespace)
-		if err != nil {
-			l.Error(err, "failed to get default node selector from namespace")
-		}
-		nodeSel = sel
+		l.Error(err, "failed to get limit override, using default limits")
 	}
-	if len(nodeSel) == 0 {
-		nodeSel = v.DefaultNodeSelector
+
+	replicas, podSpec, hasPodTemplate, err := v.requestPodTemplate(ctx, req, req.Object)
+	if err != nil {
+		l.Error(err, "failed to decode object")
+		return errored(errorCode(err, http.StatusBadRequest), err)
 	}
 
+	nodeSel := v.nodeSelectorOrDefault(ctx, podSpec, req.Namespace)
+
 	l = l.WithValues("current_ratios", ratios, "node_selector", nodeSel)
-	// If we are creating an object with resource requests, we add them to the current ratio
-	// We cannot easily do this when updating resources.
-	if req.Operation == admissionv1.Create {
+	// If we are updating an object with resource requests, we remove the requests of the old object from the current ratio
+	if req.Operation == admissionv1.Update && hasPodTemplate && len(req.OldObject.Raw) > 0 {
+		oldReplicas, oldPodSpec, oldHasPodTemplate, err := v.requestPodTemplate(ctx, req, req.OldObject)
+		if err != nil {
+			l.Error(err, "failed to decode old object")
+			return errored(errorCode(err, http.StatusBadRequest), err)
+		}
+		if oldHasPodTemplate {
+			oldNodeSel := v.nodeSelectorOrDefault(ctx, oldPodSpec, req.Namespace)
+			if r := ratios[fuzzyMatchRatioKey(labels.Set(oldNodeSel).String(), ratios)]; r != nil {
+				r.RemovePodTemplate(oldReplicas, oldPodSpec)
+			}
+		}
+	}
+	// If we are creating or updating an object with resource requests, we add them to the current ratio
+	if (req.Operation == admissionv1.Create || req.Operation == admissionv1.Update) && hasPodTemplate {
 		key := fuzzyMatchRatioKey(labels.Set(nodeSel).String(), ratios)
 		r := ratios[key]
 		if r == nil {
 			r = ratio.NewRatio()
 		}
-		r, err = v.recordObject(ctx, r, req)
-		if err != nil {
-			l.Error(err, "failed to record object")
-			return errored(http.StatusBadRequest, err)
-		}
+		r = r.RecordPodTemplate(replicas, podSpec)
 		ratios[key] = r
 
 		l = l.WithValues("ratio", r)
+
+		if req.Operation == admissionv1.Create {
+			deny, reason, err := v.enforce(ctx, req, ratioLimits, key, r)
+			if err != nil {
+				l.Error(err, "failed to check enforcement")
+				return errored(http.StatusInternalServerError, err)
+			}
+			if deny {
+				l.Info("denied: ratio below enforced hard limit")
+				return admission.Denied(reason)
+			}
+		}
 	}
 
 	warnings := make([]string, 0, len(ratios))
+	if v.LimitAnalysis && hasPodTemplate && (req.Operation == admissionv1.Create || req.Operation == admissionv1.Update) {
+		object := req.Kind.Kind
+		if req.Name != "" {
+			object = fmt.Sprintf("%s %q", req.Kind.Kind, req.Name)
+		}
+		analysis := ratio.AnalyzePod(podSpec)
+		l.V(1).Info("analyzed pod template", "qos_class", analysis.QOSClass, "limit_ratio", analysis.Limits)
+		warnings = append(warnings, analysis.Warnings(object, ratioLimits.GetLimitForNodeSelector(nodeSel))...)
+	}
 	for nodeSel, r := range ratios {
 		sel, err := labels.ConvertSelectorToLabelsMap(nodeSel)
 		if err != nil {
 			return errored(http.StatusInternalServerError, err)
 		}
-		limit := v.RatioLimits.GetLimitForNodeSelector(sel)
+		limit := ratioLimits.GetLimitForNodeSelector(sel)
 		if limit == nil {
 			l.Info("no limit found for node selector", "nodeSelector", nodeSel)
 			continue
@@ -121,7 +191,7 @@ func (v *RatioValidator) Handle(ctx context.Context, req admission.Request) admi
 
 		if r.Below(*limit, v.RatioWarnThreshold) {
 			l.Info("ratio too low", "node_selector", nodeSel, "ratio", r)
-			warnings = append(warnings, r.Warn(limit, nodeSel))
+			warnings = append(warnings, v.warn(ctx, req, r, limit, nodeSel))
 		}
 	}
 
@@ -133,52 +203,173 @@ func (v *RatioValidator) Handle(ctx context.Context, req admission.Request) admi
 	}
 }
 
-func (v *RatioValidator) recordObject(ctx context.Context, r *ratio.Ratio, req admission.Request) (*ratio.Ratio, error) {
-	switch req.Kind.Kind {
-	case "Pod":
-		pod := corev1.Pod{}
-		if err := v.Decoder.Decode(req, &pod); err != nil {
-			return r, err
+// enforce checks if the request must be denied because the given ratio is below an enforced hard limit.
+// Requests skipped by the Skipper are never denied.
+func (v *RatioValidator) enforce(ctx context.Context, req admission.Request, ratioLimits limits.Limits, nodeSel string, r *ratio.Ratio) (deny bool, reason string, err error) {
+	sel, err := labels.ConvertSelectorToLabelsMap(nodeSel)
+	if err != nil {
+		return false, "", err
+	}
+	limit := ratioLimits.GetForNodeSelector(sel)
+	if limit == nil || !limit.Enforced() {
+		return false, "", nil
+	}
+	hardLimit := limit.GetHardLimit()
+	if hardLimit == nil || !r.Below(*hardLimit, nil) {
+		return false, "", nil
+	}
+
+	if limit.Enforcement == limits.EnforcementDenyAfterGracePeriod {
+		ns := corev1.Namespace{}
+		if err := v.Client.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns); err != nil {
+			return false, "", err
 		}
-		r = r.RecordPod(pod)
-	case "Deployment":
-		deploy := appsv1.Deployment{}
-		if err := v.Decoder.Decode(req, &deploy); err != nil {
-			return r, err
+		since, err := time.Parse(time.RFC3339, ns.Annotations[ratio.BelowHardLimitSinceAnnotation])
+		if err != nil || time.Since(since) < limit.GracePeriod.Duration {
+			return false, "", nil
 		}
-		r = r.RecordDeployment(deploy)
-	case "StatefulSet":
-		sts := appsv1.StatefulSet{}
-		if err := v.Decoder.Decode(req, &sts); err != nil {
-			return r, err
+	}
+
+	if v.Skipper != nil {
+		skip, err := v.Skipper.Skip(ctx, req)
+		if err != nil {
+			return false, "", err
+		}
+		if skip {
+			return false, "", nil
 		}
-		r = r.RecordStatefulSet(sts)
 	}
-	return r, nil
+
+	return true, fmt.Sprintf("%s Creating workloads which push the ratio below %s/core is not allowed.", v.warn(ctx, req, r, limit.Limit, nodeSel), hardLimit), nil
 }
 
-func (v *RatioValidator) getNodeSelector(req admission.Request) (map[string]string, error) {
-	switch req.Kind.Kind {
-	case "Pod":
-		pod := corev1.Pod{}
-		if err := v.Decoder.Decode(req, &pod); err != nil {
-			return nil, err
-		}
-		return pod.Spec.NodeSelector, nil
-	case "Deployment":
-		deploy := appsv1.Deployment{}
-		if err := v.Decoder.Decode(req, &deploy); err != nil {
-			return nil, err
+// warn renders the warning for the given ratio using the Warner.
+// The language is selected by the user or the namespace of the request.
+func (v *RatioValidator) warn(ctx context.Context, req admission.Request, r *ratio.Ratio, limit *resource.Quantity, nodeSel string) string {
+	if v.Warner == nil {
+		return r.Warn(limit, nodeSel)
+	}
+	l := log.FromContext(ctx)
+
+	ns := corev1.Namespace{}
+	if err := v.Client.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns); err != nil {
+		l.Error(err, "failed to get namespace for warning")
+	}
+	langSources := make([]metav1.Object, 0, 2)
+	if v.Warner.LanguageAnnotation != "" {
+		user := userv1.User{}
+		if err := v.Client.Get(ctx, client.ObjectKey{Name: req.UserInfo.Username}, &user); err != nil {
+			if !apierrors.IsNotFound(err) {
+				l.Error(err, "failed to get user for warning")
+			}
+		} else {
+			langSources = append(langSources, &user)
 		}
-		return deploy.Spec.Template.Spec.NodeSelector, nil
-	case "StatefulSet":
-		sts := appsv1.StatefulSet{}
-		if err := v.Decoder.Decode(req, &sts); err != nil {
-			return nil, err
+	}
+	langSources = append(langSources, &ns)
+
+	return v.Warner.Warn(*r, limit, nodeSel, ns, v.Warner.Language(langSources...))
+}
+
+// namespaceLimits returns the limits applicable to the given namespace.
+// The limits of the organization are returned if the ratio is aggregated over the organization.
+// The default limits are returned together with the error if the limits can't be determined.
+func (v *RatioValidator) namespaceLimits(ctx context.Context, namespace string) (limits.Limits, error) {
+	ns := corev1.Namespace{}
+	if err := v.Client.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return v.RatioLimits, err
+	}
+	if org := ratio.AggregatedOrganization(v.Aggregation, v.OrganizationLabel, ns); org != "" {
+		return ratio.OrganizationLimits(ctx, v.Client, org, v.RatioLimits)
+	}
+	return ratio.OrganizationNamespaceLimits(ctx, v.Client, v.OrganizationLabel, v.RatioLimits, ns)
+}
+
+// requestPodTemplate extracts the pod template of the given object of the request.
+// For scale subresource requests the replicas of the Scale object are combined with the pod template of the scaled workload.
+func (v *RatioValidator) requestPodTemplate(ctx context.Context, req admission.Request, raw runtime.RawExtension) (replicas int32, spec corev1.PodSpec, hasPodTemplate bool, err error) {
+	if req.SubResource == "scale" {
+		return v.scalePodTemplate(ctx, req, raw)
+	}
+	return v.decodePodTemplate(raw, req.Kind)
+}
+
+// scalePodTemplate decodes the given Scale object and returns its replicas together with the pod template of the scaled workload.
+// hasPodTemplate is false if the scaled workload is not of a known kind creating pods.
+func (v *RatioValidator) scalePodTemplate(ctx context.Context, req admission.Request, raw runtime.RawExtension) (replicas int32, spec corev1.PodSpec, hasPodTemplate bool, err error) {
+	scale := autoscalingv1.Scale{}
+	if err := v.Decoder.DecodeRaw(raw, &scale); err != nil {
+		return 0, corev1.PodSpec{}, false, err
+	}
+
+	gvk, err := v.Client.RESTMapper().KindFor(schema.GroupVersionResource{
+		Group:    req.Resource.Group,
+		Version:  req.Resource.Version,
+		Resource: req.Resource.Resource,
+	})
+	if err != nil {
+		return 0, corev1.PodSpec{}, false, fmt.Errorf("failed to get kind of scaled resource: %w", err)
+	}
+	extractor, ok := v.podTemplateExtractors().Extractor(gvk.GroupKind())
+	if !ok {
+		return 0, corev1.PodSpec{}, false, nil
+	}
+
+	reader := v.APIReader
+	if reader == nil {
+		reader = v.Client
+	}
+	workload := extractor.NewObject()
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, workload); err != nil {
+		return 0, corev1.PodSpec{}, false, fmt.Errorf("failed to get scaled %s: %w", gvk.Kind, err)
+	}
+	_, spec, hasPodTemplate = extractor.PodTemplate(workload)
+	return scale.Spec.Replicas, spec, hasPodTemplate, nil
+}
+
+// decodePodTemplate decodes the given object of the given kind and extracts its pod template.
+// hasPodTemplate is false if the object is not of a known kind creating pods.
+func (v *RatioValidator) decodePodTemplate(raw runtime.RawExtension, kind metav1.GroupVersionKind) (replicas int32, spec corev1.PodSpec, hasPodTemplate bool, err error) {
+	extractor, ok := v.podTemplateExtractors().Extractor(schema.GroupKind{Group: kind.Group, Kind: kind.Kind})
+	if !ok {
+		return 0, corev1.PodSpec{}, false, nil
+	}
+
+	obj := extractor.NewObject()
+	if err := v.Decoder.DecodeRaw(raw, obj); err != nil {
+		return 0, corev1.PodSpec{}, false, err
+	}
+	replicas, spec, hasPodTemplate = extractor.PodTemplate(obj)
+	return replicas, spec, hasPodTemplate, nil
+}
+
+// podTemplateExtractors returns the configured PodTemplateExtractors or the DefaultPodTemplateExtractors if unset.
+func (v *RatioValidator) podTemplateExtractors() ratio.PodTemplateExtractors {
+	if v.PodTemplateExtractors == nil {
+		return ratio.DefaultPodTemplateExtractors()
+	}
+	return v.PodTemplateExtractors
+}
+
+// nodeSelectorOrDefault returns the node selector of the given pod spec resolved by the NodeClassResolver.
+// If it is empty, the default node selector of the namespace or the global default node selector is returned.
+func (v *RatioValidator) nodeSelectorOrDefault(ctx context.Context, spec corev1.PodSpec, namespace string) map[string]string {
+	nodeSel, err := v.NodeClassResolver.NodeSelector(ctx, spec)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to resolve node class, falling back to node selector")
+		nodeSel = spec.NodeSelector
+	}
+	if len(nodeSel) == 0 {
+		sel, err := v.getDefaultNodeSelectorFromNamespace(ctx, namespace)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to get default node selector from namespace")
 		}
-		return sts.Spec.Template.Spec.NodeSelector, nil
+		nodeSel = sel
+	}
+	if len(nodeSel) == 0 {
+		nodeSel = v.DefaultNodeSelector
 	}
-	return nil, nil
+	return nodeSel
 }
 
 func (v *RatioValidator) getDefaultNodeSelectorFromNamespace(ctx context.Context, namespace string) (map[string]string, error) {
@@ -202,6 +393,15 @@ func errored(code int32, err error) admission.Response {
 	}
 }
 
+// errorCode returns the HTTP status code of the given API error or the fallback code for other errors.
+func errorCode(err error, fallback int32) int32 {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code
+	}
+	return fallback
+}
+
 // fuzzyMatchRatioKey returns the key in ratios that matches the node selector.
 // If there is no exact match, it returns the key that matches a subset of the labels.
 // This is done if the node selector comes from the default node selector which does
@@ -7,13 +7,19 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	oappsv1 "github.com/openshift/api/apps/v1"
+	userv1 "github.com/openshift/api/user/v1"
 	"gopkg.in/inf.v0"
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -22,8 +28,10 @@ import (
 
 	"testing"
 
+	cloudagentv1 "github.com/appuio/appuio-cloud-agent/api/v1"
 	"github.com/appuio/appuio-cloud-agent/limits"
 	"github.com/appuio/appuio-cloud-agent/ratio"
+	"github.com/appuio/appuio-cloud-agent/skipper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -39,11 +47,20 @@ func TestRatioValidator_Handle(t *testing.T) {
 		namespace    string
 		resources    []client.Object
 		object       client.Object
+		oldObject    client.Object
 		mangleObject bool
+		mangleOld    bool
 		create       bool
 		limits       limits.Limits
 		threshold    *inf.Dec
+		nodeClass    []string
+		warner       func() *ratio.Warner
+		analysis     bool
+		aggregateOrg bool
+		skip         bool
 		warn         bool
+		warning      string
+		deny         bool
 		fail         bool
 		statusCode   int32
 	}{
@@ -111,6 +128,148 @@ func TestRatioValidator_Handle(t *testing.T) {
 			}},
 			warn: true,
 		},
+		"Warn_UnfairNamespace_NodeClassFromNode": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-bar", Labels: map[string]string{"class": "bar"}},
+				},
+				podFromResources("unfair", "foo", podResource{
+					{cpu: "2", memory: "1Gi"},
+				}, func(p *corev1.Pod) {
+					p.Spec.NodeName = "node-bar"
+				}),
+			},
+			limits: limits.Limits{{
+				NodeSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{"class": "bar"},
+				},
+				Limit: requireParseQuantity(t, "1Gi"),
+			}},
+			nodeClass: []string{"class"},
+			warn:      true,
+		},
+		"Warn_UnfairDeployment_NodeClassFromAffinity": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			object: deploymentFromResources("unfair", "foo", 1, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}, func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Affinity = &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+								MatchExpressions: []corev1.NodeSelectorRequirement{
+									{Key: "class", Operator: corev1.NodeSelectorOpIn, Values: []string{"bar"}},
+								},
+							}},
+						},
+					},
+				}
+			}),
+			create: true,
+			limits: limits.Limits{{
+				NodeSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{"class": "bar"},
+				},
+				Limit: requireParseQuantity(t, "1Gi"),
+			}},
+			nodeClass: []string{"class"},
+			warn:      true,
+		},
+		"Warn_TemplatedUserLanguage": {
+			user:      "appuio#foo",
+			namespace: "bar",
+			resources: []client.Object{
+				&userv1.User{
+					ObjectMeta: metav1.ObjectMeta{Name: "appuio#foo", Annotations: map[string]string{"lang": "de"}},
+				},
+				podFromResources("unfair", "bar", podResource{
+					{cpu: "8", memory: "1Gi"},
+				}),
+			},
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "1Gi")}},
+			warner: func() *ratio.Warner {
+				w, err := ratio.NewWarner("Ratio in {{ .Namespace }} too low", map[string]string{"de": "Verhältnis in\n{{ .Namespace }} zu tief"})
+				require.NoError(t, err)
+				w.LanguageAnnotation = "lang"
+				return w
+			},
+			warn:    true,
+			warning: "Verhältnis in bar zu tief",
+		},
+		"Warn_BestEffortDeployment": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			object:    deploymentFromResources("besteffort", "foo", 1, podResource{{}}),
+			create:    true,
+			limits:    limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			analysis:  true,
+			warn:      true,
+		},
+		"Allow_BestEffortDeployment_AnalysisDisabled": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			object:    deploymentFromResources("besteffort", "foo", 1, podResource{{}}),
+			create:    true,
+			limits:    limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:      false,
+		},
+		"Warn_LimitRatio": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			object: deploymentFromResources("limits", "foo", 1, podResource{
+				{cpu: "1", memory: "4Gi"},
+			}, func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("8"),
+					corev1.ResourceMemory: resource.MustParse("4Gi"),
+				}
+			}),
+			create:   true,
+			limits:   limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			analysis: true,
+			warn:     true,
+			warning:  `Memory to CPU limit ratio of 512Mi/core of Deployment "limits" is below the fair use ratio of 4Gi/core. Limits out of proportion to the fair use ratio can lead to excessive CPU usage. Please adjust the limits.`,
+		},
+		"Allow_LimitOverride": {
+			user:      "appuio#foo",
+			namespace: "override",
+			resources: []client.Object{
+				newNamespace("override", nil, map[string]string{ratio.LimitOverrideAnnotation: "1Gi"}),
+				podFromResources("compute", "override", podResource{
+					{cpu: "2", memory: "2Gi"},
+				}),
+			},
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   false,
+		},
+		"Warn_LimitOverride": {
+			user:      "appuio#foo",
+			namespace: "override",
+			resources: []client.Object{
+				newNamespace("override", nil, map[string]string{ratio.LimitOverrideAnnotation: "8Gi"}),
+				podFromResources("compute", "override", podResource{
+					{cpu: "1", memory: "6Gi"},
+				}),
+			},
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   true,
+		},
+		"Warn_OrganizationAggregation_IgnoresLimitOverride": {
+			user:      "appuio#foo",
+			namespace: "override-org",
+			resources: []client.Object{
+				newNamespace("override-org", map[string]string{"org": "acme"}, map[string]string{ratio.LimitOverrideAnnotation: "1Gi"}),
+				podFromResources("compute", "override-org", podResource{
+					{cpu: "1", memory: "2Gi"},
+				}),
+			},
+			limits:       limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			aggregateOrg: true,
+			warn:         true,
+		},
 		"Allow_DisabledUnfairNamespace": {
 			user:      "appuio#foo",
 			namespace: "disabled-foo",
@@ -305,6 +464,270 @@ func TestRatioValidator_Handle(t *testing.T) {
 			fail:         true,
 			statusCode:   http.StatusBadRequest,
 		},
+		"Warn_ConsiderNewCronJob": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: &batchv1.CronJob{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "CronJob",
+					APIVersion: "batch/v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unfair",
+					Namespace: "foo",
+				},
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								Spec: corev1.PodSpec{
+									Containers: newTestContainers(podResource{{cpu: "4", memory: "1Gi"}}),
+								},
+							},
+						},
+					},
+				},
+			},
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   true,
+			create: true,
+		},
+		"Warn_ConsiderNewDeploymentConfig": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: &oappsv1.DeploymentConfig{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "DeploymentConfig",
+					APIVersion: "apps.openshift.io/v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unfair",
+					Namespace: "foo",
+				},
+				Spec: oappsv1.DeploymentConfigSpec{
+					Replicas: 3,
+					Template: &corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: newTestContainers(podResource{{cpu: "1", memory: "1Gi"}}),
+						},
+					},
+				},
+			},
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   true,
+			create: true,
+		},
+		"Warn_ScaleDeployment": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+				podFromResources("deploy-1", "foo", podResource{
+					{cpu: "1", memory: "1Gi"},
+				}),
+			},
+			oldObject: deploymentFromResources("deploy", "foo", 1, podResource{
+				{cpu: "1", memory: "1Gi"},
+			}),
+			object: deploymentFromResources("deploy", "foo", 20, podResource{
+				{cpu: "1", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   true,
+		},
+		"Warn_RaiseDeploymentRequests": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("deploy-1", "foo", podResource{
+					{cpu: "250m", memory: "1Gi"},
+				}),
+				podFromResources("deploy-2", "foo", podResource{
+					{cpu: "250m", memory: "1Gi"},
+				}),
+			},
+			oldObject: deploymentFromResources("deploy", "foo", 2, podResource{
+				{cpu: "250m", memory: "1Gi"},
+			}),
+			object: deploymentFromResources("deploy", "foo", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   true,
+		},
+		"Allow_ScaleDownDeployment": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+				podFromResources("deploy-1", "foo", podResource{
+					{cpu: "1", memory: "1Gi"},
+				}),
+				podFromResources("deploy-2", "foo", podResource{
+					{cpu: "1", memory: "1Gi"},
+				}),
+			},
+			oldObject: deploymentFromResources("deploy", "foo", 2, podResource{
+				{cpu: "1", memory: "1Gi"},
+			}),
+			object: deploymentFromResources("deploy", "foo", 0, podResource{
+				{cpu: "1", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:   false,
+		},
+		"Warn_FailMangledOldDeployment": {
+			user:       "appuio#foo",
+			namespace:  "foo",
+			resources:  []client.Object{},
+			oldObject:  deploymentFromResources("deploy", "foo", 2, podResource{}),
+			object:     deploymentFromResources("deploy", "foo", 2, podResource{}),
+			mangleOld:  true,
+			limits:     limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}},
+			warn:       false,
+			fail:       true,
+			statusCode: http.StatusBadRequest,
+		},
+		"Deny_EnforcedLimit": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: deploymentFromResources("unfair", "foo", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDeny,
+				HardLimit:   requireParseQuantity(t, "2Gi"),
+			}},
+			deny:   true,
+			create: true,
+		},
+		"Warn_EnforcedLimit_AboveHardLimit": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: deploymentFromResources("unfair", "foo", 1, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDeny,
+				HardLimit:   requireParseQuantity(t, "2Gi"),
+			}},
+			warn:   true,
+			create: true,
+		},
+		"Warn_EnforcedLimit_Update": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: deploymentFromResources("unfair", "foo", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDeny,
+			}},
+			warn: true,
+		},
+		"Warn_EnforcedLimit_Skipped": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			resources: []client.Object{
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+			},
+			object: deploymentFromResources("unfair", "foo", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDeny,
+			}},
+			skip:   true,
+			warn:   true,
+			create: true,
+		},
+		"Deny_EnforcedLimit_GracePeriodExpired": {
+			user:      "appuio#foo",
+			namespace: "grace",
+			resources: []client.Object{
+				newNamespace("grace", nil, map[string]string{
+					ratio.BelowHardLimitSinceAnnotation: time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+				}),
+			},
+			object: deploymentFromResources("unfair", "grace", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDenyAfterGracePeriod,
+				GracePeriod: metav1.Duration{Duration: time.Hour},
+			}},
+			deny:   true,
+			create: true,
+		},
+		"Warn_EnforcedLimit_InGracePeriod": {
+			user:      "appuio#foo",
+			namespace: "grace",
+			resources: []client.Object{
+				newNamespace("grace", nil, map[string]string{
+					ratio.BelowHardLimitSinceAnnotation: time.Now().Add(-30 * time.Minute).Format(time.RFC3339),
+				}),
+			},
+			object: deploymentFromResources("unfair", "grace", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDenyAfterGracePeriod,
+				GracePeriod: metav1.Duration{Duration: time.Hour},
+			}},
+			warn:   true,
+			create: true,
+		},
+		"Warn_EnforcedLimit_GracePeriodNotStarted": {
+			user:      "appuio#foo",
+			namespace: "foo",
+			object: deploymentFromResources("unfair", "foo", 2, podResource{
+				{cpu: "2", memory: "1Gi"},
+			}),
+			limits: limits.Limits{{
+				Limit:       requireParseQuantity(t, "4Gi"),
+				Enforcement: limits.EnforcementDenyAfterGracePeriod,
+				GracePeriod: metav1.Duration{Duration: time.Hour},
+			}},
+			warn:   true,
+			create: true,
+		},
 		"Allow_UnfairNamespace_WithThreshold": {
 			user:      "appuio#foo",
 			namespace: "bar",
@@ -333,6 +756,21 @@ func TestRatioValidator_Handle(t *testing.T) {
 			v := prepareTest(t, tc.resources...)
 			v.RatioLimits = tc.limits
 			v.RatioWarnThreshold = tc.threshold
+			v.Skipper = skipper.StaticSkipper{ShouldSkip: tc.skip}
+			v.LimitAnalysis = tc.analysis
+			if tc.warner != nil {
+				v.Warner = tc.warner()
+			}
+			v.NodeClassResolver = ratio.NodeClassResolver{Client: v.Client, NodeClassLabels: tc.nodeClass}
+			fetcher := v.Ratio.(ratio.Fetcher)
+			fetcher.NodeClassResolver = v.NodeClassResolver
+			if tc.aggregateOrg {
+				v.OrganizationLabel = "org"
+				v.Aggregation = ratio.AggregationOrganization
+				fetcher.Aggregation = ratio.AggregationOrganization
+				fetcher.AggregationLabel = "org"
+			}
+			v.Ratio = fetcher
 
 			ar := admission.Request{
 				AdmissionRequest: admissionv1.AdmissionRequest{
@@ -372,6 +810,17 @@ func TestRatioValidator_Handle(t *testing.T) {
 				}
 
 			}
+			if tc.oldObject != nil {
+				raw, err := json.Marshal(tc.oldObject)
+				require.NoError(t, err)
+				if tc.mangleOld {
+					raw = []byte("?invalid")
+				}
+
+				ar.AdmissionRequest.OldObject = runtime.RawExtension{
+					Raw: raw,
+				}
+			}
 			if tc.create {
 				ar.AdmissionRequest.Operation = admissionv1.Create
 			}
@@ -383,6 +832,12 @@ func TestRatioValidator_Handle(t *testing.T) {
 				assert.True(t, resp.Allowed)
 				return
 			}
+			if tc.deny {
+				assert.False(t, resp.Allowed)
+				require.NotNil(t, resp.AdmissionResponse.Result)
+				assert.EqualValues(t, http.StatusForbidden, resp.AdmissionResponse.Result.Code)
+				return
+			}
 			if resp.AdmissionResponse.Result != nil {
 				assert.EqualValues(t, http.StatusOK, resp.AdmissionResponse.Result.Code)
 			}
@@ -392,6 +847,91 @@ func TestRatioValidator_Handle(t *testing.T) {
 				for _, w := range resp.AdmissionResponse.Warnings {
 					assert.NotContainsf(t, w, "\n", "Warning are not allowed to contain newlines")
 				}
+				if tc.warning != "" {
+					assert.Contains(t, resp.AdmissionResponse.Warnings, tc.warning)
+				}
+			} else {
+				assert.Empty(t, resp.AdmissionResponse.Warnings)
+			}
+		})
+	}
+}
+
+func TestRatioValidator_Handle_Scale(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]struct {
+		resource    metav1.GroupVersionResource
+		oldReplicas int32
+		replicas    int32
+		warn        bool
+		fail        bool
+		statusCode  int32
+	}{
+		"Warn_ScaleUp": {
+			resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			oldReplicas: 1,
+			replicas:    20,
+			warn:        true,
+		},
+		"Allow_ScaleDown": {
+			resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			oldReplicas: 1,
+			replicas:    0,
+			warn:        false,
+		},
+		"Fail_WorkloadNotFound": {
+			resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"},
+			oldReplicas: 1,
+			replicas:    20,
+			fail:        true,
+			statusCode:  http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range tests {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := prepareTest(t,
+				podFromResources("pod1", "foo", podResource{
+					{cpu: "100m", memory: "4Gi"},
+				}),
+				podFromResources("deploy-1", "foo", podResource{
+					{cpu: "1", memory: "1Gi"},
+				}),
+				deploymentFromResources("deploy", "foo", tc.oldReplicas, podResource{
+					{cpu: "1", memory: "1Gi"},
+				}),
+			)
+			v.RatioLimits = limits.Limits{{Limit: requireParseQuantity(t, "4Gi")}}
+
+			ar := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					UID:         "e515f52d-7181-494d-a3d3-f0738856bd97",
+					Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+					Resource:    tc.resource,
+					SubResource: "scale",
+					Name:        "deploy",
+					Namespace:   "foo",
+					Operation:   admissionv1.Update,
+					UserInfo: authenticationv1.UserInfo{
+						Username: "appuio#foo",
+					},
+					Object:    runtime.RawExtension{Raw: scaleFromReplicas(t, "deploy", "foo", tc.replicas)},
+					OldObject: runtime.RawExtension{Raw: scaleFromReplicas(t, "deploy", "foo", tc.oldReplicas)},
+				},
+			}
+
+			resp := v.Handle(ctx, ar)
+			assert.True(t, resp.Allowed)
+			if tc.fail {
+				require.NotNil(t, resp.AdmissionResponse.Result)
+				assert.Equal(t, tc.statusCode, resp.AdmissionResponse.Result.Code)
+				return
+			}
+			if tc.warn {
+				assert.NotEmpty(t, resp.AdmissionResponse.Warnings)
 			} else {
 				assert.Empty(t, resp.AdmissionResponse.Warnings)
 			}
@@ -404,6 +944,9 @@ func prepareTest(t *testing.T, initObjs ...client.Object) *RatioValidator {
 
 	scheme := runtime.NewScheme()
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(oappsv1.AddToScheme(scheme))
+	utilruntime.Must(userv1.AddToScheme(scheme))
+	utilruntime.Must(cloudagentv1.AddToScheme(scheme))
 
 	decoder := admission.NewDecoder(scheme)
 	barNs := newNamespace("bar", nil, nil)
@@ -426,8 +969,13 @@ func prepareTest(t *testing.T, initObjs ...client.Object) *RatioValidator {
 	}
 
 	initObjs = append(initObjs, newNamespace("foo", nil, nil), barNs, disabledNs, otherDisabledNs, nsWithDefaultNodeSelector)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), meta.RESTScopeNamespace)
+
 	client := fake.NewClientBuilder().
 		WithScheme(scheme).
+		WithRESTMapper(mapper).
 		WithObjects(initObjs...).
 		Build()
 
@@ -523,6 +1071,24 @@ func statefulsetFromResources(name, namespace string, replicas int32, res podRes
 	return &sts
 }
 
+func scaleFromReplicas(t *testing.T, name, namespace string, replicas int32) []byte {
+	raw, err := json.Marshal(autoscalingv1.Scale{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Scale",
+			APIVersion: "autoscaling/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: autoscalingv1.ScaleSpec{
+			Replicas: replicas,
+		},
+	})
+	require.NoError(t, err)
+	return raw
+}
+
 func newTestContainers(res []containerResources) []corev1.Container {
 	var containers []corev1.Container
 	for _, cr := range res {